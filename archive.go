@@ -0,0 +1,221 @@
+package piston
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractArchive unpacks a .zip or .tar.gz archive into dest, telling the two
+// apart by their leading bytes rather than by file name.
+func extractArchive(ctx context.Context, src string, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	if err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return unzip(ctx, src, dest)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		return untar(ctx, f, dest)
+	default:
+		return fmt.Errorf("unsupported archive format: %s", src)
+	}
+}
+
+// safeJoin joins name onto dest, refusing names that would escape it.
+func safeJoin(dest string, name string) (string, error) {
+	fpath := filepath.Join(dest, name)
+	if !within(dest, fpath) {
+		return "", fmt.Errorf("illegal file path: %s", fpath)
+	}
+	return fpath, nil
+}
+
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+func unzip(ctx context.Context, src string, dest string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		fpath, err := safeJoin(dest, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(fpath, os.ModePerm)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
+			return err
+		}
+
+		in, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		err = writeExtracted(ctx, fpath, in, f.Mode().Perm())
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untar unpacks a gzip-compressed tarball, keeping permission bits and
+// recreating symbolic and hard links. Every entry is written through the real
+// path of its directory, with the links extracted so far followed, and is
+// refused unless that path is inside dest.
+func untar(ctx context.Context, r io.Reader, dest string) error {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fpath, err := safeJoin(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if fpath == root {
+			continue
+		}
+		parent, err := extractParent(root, fpath)
+		if err != nil {
+			return err
+		}
+		fpath = filepath.Join(parent, filepath.Base(fpath))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(fpath, 0755)
+		case tar.TypeReg:
+			removeSymlink(fpath)
+			err = writeExtracted(ctx, fpath, tr, os.FileMode(hdr.Mode).Perm())
+		case tar.TypeSymlink:
+			target := filepath.Join(parent, hdr.Linkname)
+			if filepath.IsAbs(hdr.Linkname) || !within(root, target) {
+				return fmt.Errorf("illegal link target: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			os.Remove(fpath)
+			err = os.Symlink(hdr.Linkname, fpath)
+		case tar.TypeLink:
+			// Linking the resolved target keeps a link to a symlink from
+			// being reinterpreted relative to its new directory.
+			var target string
+			target, err = safeJoin(root, hdr.Linkname)
+			if err == nil {
+				target, err = filepath.EvalSymlinks(target)
+			}
+			if err != nil || !within(root, target) {
+				return fmt.Errorf("illegal link target: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			os.Remove(fpath)
+			err = os.Link(target, fpath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractParent creates the directory of path and returns its real path,
+// failing when a symlink extracted earlier leads it outside root.
+func extractParent(root string, path string) (string, error) {
+	parent := filepath.Dir(path)
+
+	existing := parent
+	for existing != root {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil || !within(root, real) {
+		return "", fmt.Errorf("illegal file path: %s", path)
+	}
+
+	rest, err := filepath.Rel(existing, parent)
+	if err != nil {
+		return "", err
+	}
+	parent = filepath.Join(real, rest)
+	return parent, os.MkdirAll(parent, 0755)
+}
+
+// removeSymlink removes path if it is a symlink, so that writing to path
+// does not follow it.
+func removeSymlink(path string) {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(path)
+	}
+}
+
+func writeExtracted(ctx context.Context, path string, r io.Reader, perm os.FileMode) error {
+	if perm == 0 {
+		perm = 0644
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, contextReader{ctx, r})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
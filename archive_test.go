@@ -0,0 +1,122 @@
+package piston
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func tarGz(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0644, Size: int64(len(entry.content))}
+		if entry.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		err := tw.WriteHeader(hdr)
+		if err == nil {
+			_, err = tw.Write([]byte(entry.content))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUntar(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	dest := filepath.Join(t.TempDir(), "jdk")
+	archive := tarGz(t, []tarEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "jdk/bin/", typeflag: tar.TypeDir},
+		{name: "jdk/bin/java", typeflag: tar.TypeReg, content: "java"},
+		{name: "jdk/lib/libjli.so", typeflag: tar.TypeReg, content: "jli"},
+		{name: "jdk/bin/libjli.so", typeflag: tar.TypeSymlink, linkname: "../lib/libjli.so"},
+		{name: "jdk/lib/java", typeflag: tar.TypeLink, linkname: "jdk/bin/java"},
+	})
+
+	err := untar(context.Background(), bytes.NewReader(archive), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"jdk/bin/java": "java", "jdk/bin/libjli.so": "jli", "jdk/lib/java": "java"} {
+		data, err := os.ReadFile(filepath.Join(dest, path))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+		}
+	}
+}
+
+func TestUntarRefusesEscapes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	tests := map[string][]tarEntry{
+		"dot dot":       {{name: "../escaped.txt", typeflag: tar.TypeReg, content: "x"}},
+		"absolute link": {{name: "l", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+		"outside link":  {{name: "a/l", typeflag: tar.TypeSymlink, linkname: "../../.."}},
+		// Each link points inside dest where it is created, but following
+		// them one after another leads two levels above dest.
+		"chained links": {
+			{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "a/b/l", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "a/b/l/escaped.txt", typeflag: tar.TypeReg, content: "x"},
+		},
+		"hard link through symlinks": {
+			{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "a/b/l", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "hard", typeflag: tar.TypeLink, linkname: "a/b/l/secret.txt"},
+		},
+		"write through link": {
+			{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "a/b/l", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "file", typeflag: tar.TypeSymlink, linkname: "a/b/l/escaped.txt"},
+			{name: "file", typeflag: tar.TypeReg, content: "x"},
+		},
+	}
+
+	for name, entries := range tests {
+		t.Run(name, func(t *testing.T) {
+			// dest sits two levels below outside, where the escapes aim.
+			outside := t.TempDir()
+			dest := filepath.Join(outside, "x", "jdk")
+			err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = untar(context.Background(), bytes.NewReader(tarGz(t, entries)), dest)
+			if _, statErr := os.Stat(filepath.Join(outside, "escaped.txt")); statErr == nil {
+				t.Fatal("a file was written outside dest")
+			}
+			if name != "write through link" && err == nil {
+				t.Error("untar accepted the archive")
+			}
+		})
+	}
+}
@@ -1,84 +1,85 @@
 package piston
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 )
 
-func downloadAsset(obj AssetObject, baseDir string) {
+// assetTask returns the download of obj. Its hash names the file, so one that
+// is not a SHA-1 is rejected rather than turned into a path.
+func assetTask(obj AssetObject, baseDir string) (downloadTask, error) {
+	if !validSHA1(obj.Hash) {
+		return downloadTask{}, fmt.Errorf("%w: %q", ErrInvalidAssetHash, obj.Hash)
+	}
+
 	prefix := obj.Hash[:2]
-	url := fmt.Sprintf("https://resources.download.minecraft.net/%s/%s", prefix, obj.Hash)
-	dest := filepath.Join(baseDir, "assets", "objects", prefix, obj.Hash)
+	return downloadTask{
+		URL:  fmt.Sprintf("https://%s/%s/%s", MojangResourcesHost, prefix, obj.Hash),
+		Dest: filepath.Join(baseDir, "assets", "objects", prefix, obj.Hash),
+		SHA1: obj.Hash,
+		Size: int64(obj.Size),
+	}, nil
+}
 
-	if fileExists(dest) && sha1Matches(dest, obj.Hash) {
-		return
+// loadAssetIndex returns the asset index, downloading it to indexPath unless
+// the file there already matches its SHA-1 and size.
+func loadAssetIndex(ctx context.Context, sched *scheduler, assetIndex AssetIndex, indexPath string) ([]byte, error) {
+	task := downloadTask{URL: assetIndex.URL, Dest: indexPath, SHA1: assetIndex.SHA1, Size: int64(assetIndex.Size)}
+	if !task.valid() {
+		sched.logger.Printf("Fetching asset index: %s", assetIndex.URL)
+		startPhase(sched.progress, PhaseManifest, 1, task.Size)
+		err := sched.run(ctx, PhaseManifest, []downloadTask{task})
+		if finishPhase(sched.progress, PhaseManifest, err) != nil {
+			return nil, err
+		}
 	}
 
-	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	data, err := os.ReadFile(indexPath)
 	if err != nil {
-		log.Fatalf("Failed to create asset directory: %s", err)
+		return nil, fmt.Errorf("failed to read asset index file: %w", err)
 	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Fatalf("Failed to fetch asset: %s", err)
-	}
-	defer resp.Body.Close()
+	return data, nil
+}
 
-	out, err := os.Create(dest)
+func downloadAssets(ctx context.Context, sched *scheduler, meta *VersionMeta, baseDir string) error {
+	indexPath := filepath.Join(baseDir, "assets", "indexes", meta.AssetIndex.ID+".json")
+	data, err := loadAssetIndex(ctx, sched, meta.AssetIndex, indexPath)
 	if err != nil {
-		log.Fatalf("Failed to create asset file: %s", err)
+		return err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	var index AssetIndexFile
+	err = json.Unmarshal(data, &index)
 	if err != nil {
-		log.Fatalf("Failed to write asset: %s", err)
+		return fmt.Errorf("failed to parse asset index: %w", err)
 	}
-}
-
-func downloadAssets(meta *VersionMeta, baseDir string) {
-    log.Printf("Fetching asset index: %s", meta.AssetIndex.URL)
-    resp, err := http.Get(meta.AssetIndex.URL)
-    if err != nil {
-        log.Fatalf("Failed to fetch asset index: %s", err)
-    }
-    defer resp.Body.Close()
-
-    data, err := io.ReadAll(resp.Body)
-    if err != nil {
-        log.Fatalf("Failed to read asset index: %s", err)
-    }
 
-    indexPath := filepath.Join(baseDir, "assets", "indexes", meta.AssetIndex.ID+".json")
-    err = os.MkdirAll(filepath.Dir(indexPath), 0755)
-    if err != nil {
-        log.Fatalf("Failed to create indexes directory: %s", err)
-    }
-    err = os.WriteFile(indexPath, data, 0644)
-    if err != nil {
-        log.Fatalf("Failed to write asset index file: %s", err)
-    }
+	// Several asset names may share one object, so fetch each hash only once.
+	seen := map[string]bool{}
+	tasks := make([]downloadTask, 0, len(index.Objects))
+	for _, obj := range index.Objects {
+		if seen[obj.Hash] {
+			continue
+		}
+		seen[obj.Hash] = true
 
-    var index AssetIndexFile
-    err = json.Unmarshal(data, &index)
-    if err != nil {
-        log.Fatalf("Failed to parse asset index: %s", err)
-    }
+		task, err := assetTask(obj, baseDir)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
 
-    count := 0
-    for _, obj := range index.Objects {
-        downloadAsset(obj, baseDir)
-        count++
-        if count%100 == 0 {
-            log.Printf("Downloaded %d assets...", count)
-        }
-    }
+	startPhase(sched.progress, PhaseAssets, len(tasks), tasksSize(tasks))
+	err = sched.run(ctx, PhaseAssets, tasks)
+	if finishPhase(sched.progress, PhaseAssets, err) != nil {
+		return err
+	}
 
-    log.Printf("All assets downloaded (%d files)", count)
+	sched.logger.Printf("All assets downloaded (%d files)", len(tasks))
+	return nil
 }
@@ -0,0 +1,25 @@
+package piston
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetTask(t *testing.T) {
+	const hash = "bdf48ef6b5d0d23bbb02e17d04865216179f510a"
+	task, err := assetTask(AssetObject{Hash: hash, Size: 9}, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("base", "assets", "objects", "bd", hash); task.Dest != want {
+		t.Errorf("Dest = %s, want %s", task.Dest, want)
+	}
+
+	for _, hash := range []string{"", "b", "bdf48ef6", hash + "0", "zzf48ef6b5d0d23bbb02e17d04865216179f510a", "../../../../../../../../../../etc/passwd"} {
+		_, err := assetTask(AssetObject{Hash: hash}, "base")
+		if !errors.Is(err, ErrInvalidAssetHash) {
+			t.Errorf("assetTask(%q) = %v, want ErrInvalidAssetHash", hash, err)
+		}
+	}
+}
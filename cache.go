@@ -0,0 +1,115 @@
+package piston
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultManifestTTL = 10 * time.Minute
+
+// Documents whose URL embeds their hash never change and are cached forever.
+const immutable time.Duration = -1
+
+// cachePath maps url onto a file in a directory per host, named after a hash
+// of its path and query plus its last path element for readability, e.g.
+// cache/piston-meta.mojang.com/fb1c124a6ff3b7d7-version_manifest_v2.json.
+// Hashing keeps URLs that differ only in their query apart, and a URL whose
+// path is a prefix of another's from needing to be both a file and a directory.
+func (s *scheduler) cachePath(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(u.EscapedPath() + "?" + u.RawQuery))
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "index"
+	}
+	host := strings.ReplaceAll(u.Host, ":", "_")
+	return filepath.Join(s.cacheDir, host, hex.EncodeToString(sum[:8])+"-"+name), nil
+}
+
+// fetchCached decodes the document at url, preferring the cached copy while
+// it is younger than ttl (a negative ttl never expires, zero always refetches).
+// In offline mode only the cache is consulted. When the network fails or
+// returns a document decode rejects, a stale cached copy is decoded instead of
+// the error. Only documents decode accepts are cached.
+func (s *scheduler) fetchCached(ctx context.Context, url string, ttl time.Duration, decode func([]byte) error) error {
+	path, err := s.cachePath(url)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", url, err)
+	}
+
+	info, statErr := os.Stat(path)
+	cached := statErr == nil && !info.IsDir()
+	if cached && (s.offline || ttl < 0 || time.Since(info.ModTime()) < ttl) {
+		data, err := os.ReadFile(path)
+		if err == nil && decode(data) == nil {
+			return nil
+		}
+	}
+
+	if s.offline {
+		return fmt.Errorf("%w: %s is not cached", ErrOffline, url)
+	}
+
+	data, err := s.fetchBytes(ctx, url)
+	if err == nil {
+		err = decode(data)
+	}
+	if err != nil {
+		if cached && ctx.Err() == nil {
+			stale, readErr := os.ReadFile(path)
+			if readErr == nil && decode(stale) == nil {
+				s.logger.Printf("Using cached %s: %s", url, err)
+				return nil
+			}
+		}
+		return err
+	}
+
+	err = writeFileAtomic(path, data)
+	if err != nil {
+		s.logger.Printf("Failed to cache %s: %s", url, err)
+	}
+
+	return nil
+}
+
+// fetchVerifiedJSON decodes the document at url, which must have the given
+// SHA-1. The cached copy is used for as long as it matches; otherwise the
+// document is downloaded like any other file, so a corrupt response is
+// retried and never replaces the cached copy.
+func (s *scheduler) fetchVerifiedJSON(ctx context.Context, url string, sha1 string, v any) error {
+	path, err := s.cachePath(url)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", url, err)
+	}
+
+	err = s.run(ctx, PhaseManifest, []downloadTask{{URL: url, Dest: path, SHA1: sha1}})
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalJSON(url, data, v)
+}
+
+func (s *scheduler) fetchCachedJSON(ctx context.Context, url string, ttl time.Duration, v any) error {
+	return s.fetchCached(ctx, url, ttl, func(data []byte) error {
+		return unmarshalJSON(url, data, v)
+	})
+}
@@ -0,0 +1,123 @@
+package piston
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachePath(t *testing.T) {
+	s := &scheduler{cacheDir: "cache"}
+	path := func(url string) string {
+		t.Helper()
+		path, err := s.cachePath(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	manifest := path("https://piston-meta.mojang.com/mc/game/version_manifest_v2.json")
+	if want := filepath.Join("cache", "piston-meta.mojang.com", "fb1c124a6ff3b7d7-version_manifest_v2.json"); manifest != want {
+		t.Errorf("cachePath = %s, want %s", manifest, want)
+	}
+
+	distinct := []string{
+		"https://api.adoptium.net/v3/assets/latest/17/hotspot?architecture=x64&os=linux",
+		"https://api.adoptium.net/v3/assets/latest/17/hotspot?architecture=aarch64&os=linux",
+		"https://api.adoptium.net/v3/assets/latest/17/hotspot",
+		"https://meta.fabricmc.net/v2/versions",
+		"https://meta.fabricmc.net/v2/versions/loader/1.20.1",
+		"https://meta.fabricmc.net/",
+		"http://localhost:8080/v2/versions",
+	}
+	seen := map[string]string{}
+	for _, url := range distinct {
+		p := path(url)
+		if other, ok := seen[p]; ok {
+			t.Errorf("%s and %s are both cached at %s", url, other, p)
+		}
+		seen[p] = url
+
+		// Every entry is a file directly in its host's directory, so no
+		// entry is the parent directory of another.
+		if filepath.Dir(filepath.Dir(p)) != "cache" {
+			t.Errorf("%s is cached at %s", url, p)
+		}
+	}
+}
+
+func TestFetchCachedNestedPaths(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 1)
+	ctx := context.Background()
+	for _, uri := range []string{"/v2/versions", "/v2/versions/loader/1.20.1", "/v2/versions?a=1", "/v2/versions?a=2"} {
+		for range 2 {
+			var data []byte
+			err := s.fetchCached(ctx, srv.URL+uri, time.Hour, func(b []byte) error {
+				data = b
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != uri {
+				t.Errorf("fetchCached(%s) = %q", uri, data)
+			}
+		}
+	}
+}
+
+func TestFetchCachedKeepsOnlyDecodedDocuments(t *testing.T) {
+	var body atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 1)
+	ctx := context.Background()
+	url := srv.URL + "/version.json"
+	path, err := s.cachePath(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct{ ID string }
+	body.Store(`{"id": "1.2`)
+	err = s.fetchCachedJSON(ctx, url, time.Hour, &doc)
+	if err == nil {
+		t.Fatal("a cut-off document was decoded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("a cut-off document was cached")
+	}
+
+	body.Store(`{"id": "1.21"}`)
+	err = s.fetchCachedJSON(ctx, url, time.Hour, &doc)
+	if err != nil || doc.ID != "1.21" {
+		t.Fatalf("ID = %q, %v; want 1.21", doc.ID, err)
+	}
+
+	// An error page served in place of the document falls back to the
+	// cached copy and does not replace it.
+	body.Store(`<html>Service Unavailable</html>`)
+	doc.ID = ""
+	err = s.fetchCachedJSON(ctx, url, 0, &doc)
+	if err != nil || doc.ID != "1.21" {
+		t.Fatalf("ID = %q, %v; want the cached 1.21", doc.ID, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != `{"id": "1.21"}` {
+		t.Errorf("cached %q, %v", data, err)
+	}
+}
@@ -1,72 +1,62 @@
 package piston
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 )
 
-func downloadClientJar(meta *VersionMeta, baseDir string) {
+func downloadClientJar(ctx context.Context, sched *scheduler, meta *VersionMeta, baseDir string) error {
 	clientDownload, ok := meta.Downloads["client"]
 	if !ok {
-		log.Fatalf("No client download in manifest!")
+		return ErrNoClientDownload
 	}
 
-	destDir := filepath.Join(baseDir, "versions", meta.ID)
-	destJar := filepath.Join(destDir, meta.ID+".jar")
+	destJar := filepath.Join(baseDir, "versions", meta.ID, meta.ID+".jar")
 
-	if fileExists(destJar) && sha1Matches(destJar, clientDownload.SHA1) {
-		log.Printf("client.jar for %s already exists and is valid.", meta.ID)
-		return
-	}
-
-	log.Printf("Downloading client.jar for %s", meta.ID)
-	err := os.MkdirAll(destDir, 0755)
-	if err != nil {
-		log.Fatalf("Failed to create version dir: %s", err)
-	}
-
-	resp, err := http.Get(clientDownload.URL)
-	if err != nil {
-		log.Fatalf("Failed to fetch client.jar: %s", err)
-	}
-	defer resp.Body.Close()
+	tasks := []downloadTask{{
+		URL:       clientDownload.URL,
+		Dest:      destJar,
+		SHA1:      clientDownload.SHA1,
+		Size:      int64(clientDownload.Size),
+		Resumable: true,
+	}}
 
-	out, err := os.Create(destJar)
-	if err != nil {
-		log.Fatalf("Failed to create client.jar file: %s", err)
+	sched.logger.Printf("Downloading client.jar for %s", meta.ID)
+	startPhase(sched.progress, PhaseClientJar, len(tasks), tasksSize(tasks))
+	err := sched.run(ctx, PhaseClientJar, tasks)
+	if finishPhase(sched.progress, PhaseClientJar, err) != nil {
+		return err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to write client.jar: %s", err)
-	}
+	sched.logger.Printf("client.jar for %s is ready at %s", meta.ID, destJar)
+	return nil
+}
 
-	versionJSONPath := filepath.Join(destDir, meta.ID+".json")
+func saveVersionManifest(meta *VersionMeta, baseDir string) error {
+	versionJSONPath := filepath.Join(baseDir, "versions", meta.ID, meta.ID+".json")
 
-	file, err := os.Create(versionJSONPath)
+	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
-		log.Fatalf("Failed to create version.json file: %s", err)
+		return fmt.Errorf("failed to encode version.json: %w", err)
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	err = encoder.Encode(meta)
+	err = writeFileAtomic(versionJSONPath, data)
 	if err != nil {
-		log.Fatalf("Failed to write version.json: %s", err)
+		return fmt.Errorf("failed to write version.json: %w", err)
 	}
 
-	log.Printf("client.jar downloaded to %s", destJar)
+	return nil
 }
 
 func loadVersionManifest(baseDir string, version string) (*VersionMeta, error) {
 	file, err := os.Open(filepath.Join(baseDir, "versions", version, version+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotInstalled, version)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open version.json: %w", err)
 	}
@@ -80,4 +70,4 @@ func loadVersionManifest(baseDir string, version string) (*VersionMeta, error) {
 	}
 
 	return &meta, nil
-}
\ No newline at end of file
+}
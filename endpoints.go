@@ -0,0 +1,62 @@
+package piston
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	MojangMetaHost         = "piston-meta.mojang.com"
+	MojangLauncherMetaHost = "launchermeta.mojang.com"
+	MojangLauncherHost     = "launcher.mojang.com"
+	MojangDataHost         = "piston-data.mojang.com"
+	MojangLibrariesHost    = "libraries.minecraft.net"
+	MojangResourcesHost    = "resources.download.minecraft.net"
+	FabricMetaHost         = "meta.fabricmc.net"
+	FabricMavenHost        = "maven.fabricmc.net"
+	AdoptiumAPIHost        = "api.adoptium.net"
+	ForgeMavenHost         = "maven.minecraftforge.net"
+	NeoForgedMavenHost     = "maven.neoforged.net"
+)
+
+// Endpoints maps a host the launcher talks to onto the base URL of a mirror.
+// A request for https://<host>/<path> is sent to <base>/<path> instead, so
+// both the launcher's own URLs and those found inside downloaded manifests
+// are redirected. Hosts that are not listed are contacted directly.
+type Endpoints map[string]string
+
+// BMCLAPIEndpoints routes Mojang, Fabric and Forge traffic through the
+// BMCLAPI mirror.
+var BMCLAPIEndpoints = Endpoints{
+	MojangMetaHost:         "https://bmclapi2.bangbang93.com",
+	MojangLauncherMetaHost: "https://bmclapi2.bangbang93.com",
+	MojangLauncherHost:     "https://bmclapi2.bangbang93.com",
+	MojangDataHost:         "https://bmclapi2.bangbang93.com",
+	MojangLibrariesHost:    "https://bmclapi2.bangbang93.com/maven",
+	MojangResourcesHost:    "https://bmclapi2.bangbang93.com/assets",
+	FabricMetaHost:         "https://bmclapi2.bangbang93.com/fabric-meta",
+	FabricMavenHost:        "https://bmclapi2.bangbang93.com/maven",
+	ForgeMavenHost:         "https://bmclapi2.bangbang93.com/maven",
+}
+
+func (e Endpoints) resolve(rawURL string) string {
+	if len(e) == 0 {
+		return rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	base, ok := e[u.Host]
+	if !ok {
+		return rawURL
+	}
+
+	rest := u.EscapedPath()
+	if u.RawQuery != "" {
+		rest += "?" + u.RawQuery
+	}
+	return strings.TrimSuffix(base, "/") + rest
+}
@@ -0,0 +1,67 @@
+package piston
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrVersionNotInstalled  = errors.New("version not installed")
+	ErrNoClientDownload     = errors.New("no client download in manifest")
+	ErrFabricLoaderNotFound = errors.New("fabric loader not found")
+	ErrInvalidLibraryName   = errors.New("invalid library name")
+	ErrJavaNotFound         = errors.New("java executable not found")
+	ErrOffline              = errors.New("offline mode")
+	ErrJavaBroken           = errors.New("java runtime is broken")
+	ErrInstanceNotFound     = errors.New("instance not found")
+	ErrInstanceExists       = errors.New("instance already exists")
+	ErrInvalidInstanceName  = errors.New("invalid instance name")
+	ErrNativeConflict       = errors.New("conflicting native libraries")
+	ErrUnknownVersion       = errors.New("unknown minecraft version")
+	ErrUnsupportedInstaller = errors.New("unsupported installer")
+	ErrNeoForgeNotFound     = errors.New("neoforge version not found")
+	ErrInvalidAssetHash     = errors.New("invalid asset hash")
+)
+
+// DownloadError is returned when fetching URL fails, either because the
+// request itself failed (Cause) or the server answered with a non-200 Status.
+type DownloadError struct {
+	URL    string
+	Status int
+	Cause  error
+}
+
+func (e *DownloadError) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("download %s: unexpected status %d", e.URL, e.Status)
+	}
+	return fmt.Sprintf("download %s: %s", e.URL, e.Cause)
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Cause
+}
+
+// ChecksumError is returned when a file on disk does not match the hash
+// published for it in a manifest.
+type ChecksumError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// SizeError is returned when a downloaded file is shorter or longer than the
+// size published for it in a manifest.
+type SizeError struct {
+	Path     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("size mismatch for %s: expected %d bytes, got %d", e.Path, e.Expected, e.Actual)
+}
@@ -0,0 +1,252 @@
+package piston
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func (s *scheduler) httpGet(ctx context.Context, url string) (*http.Response, error) {
+	return s.httpGetRange(ctx, url, 0, "")
+}
+
+// httpGetRange requests url, rewritten through the configured endpoints,
+// starting at offset. When offset is positive the server may answer 206 with
+// the remaining bytes, or 200 with the whole file if it does not support
+// ranges or the file no longer matches validator.
+func (s *scheduler) httpGetRange(ctx context.Context, url string, offset int64, validator string) (*http.Response, error) {
+	if s.offline {
+		return nil, &DownloadError{URL: url, Cause: ErrOffline}
+	}
+
+	url = s.endpoints.resolve(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &DownloadError{URL: url, Cause: err}
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, &DownloadError{URL: url, Cause: err}
+	}
+
+	if resp.StatusCode != http.StatusOK && !(offset > 0 && resp.StatusCode == http.StatusPartialContent) {
+		resp.Body.Close()
+		return nil, &DownloadError{URL: url, Status: resp.StatusCode}
+	}
+
+	return resp, nil
+}
+
+func (s *scheduler) fetchBytes(ctx context.Context, url string) ([]byte, error) {
+	var data []byte
+	err := retry(ctx, s.retry, func() error {
+		resp, err := s.httpGet(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return &DownloadError{URL: url, Cause: err}
+		}
+		return nil
+	})
+
+	return data, err
+}
+
+func (s *scheduler) fetchJSON(ctx context.Context, url string, v any) error {
+	data, err := s.fetchBytes(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalJSON(url, data, v)
+}
+
+func unmarshalJSON(url string, data []byte, v any) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", url, err)
+	}
+
+	return nil
+}
+
+// downloadFile streams task.URL into a temporary file next to task.Dest,
+// hashing it on the way, and renames it into place only once the size and
+// checksums match the task. Progress, when non-nil, receives the written bytes.
+//
+// Callers must hold the destination's lock from the task's download slots, as
+// the temporary file is named after task.Dest.
+//
+// Resumable tasks keep their temporary file when the transfer is cut short,
+// and the next attempt asks the server only for the missing bytes. In every
+// other failure, including ctx being cancelled mid-transfer, the temporary
+// file is removed and task.Dest is left untouched.
+func (s *scheduler) downloadFile(ctx context.Context, task downloadTask, progress *progressWriter) error {
+	err := os.MkdirAll(filepath.Dir(task.Dest), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := task.Dest + ".part"
+	offset, validator := resumePoint(task, tmp)
+
+	resp, err := s.httpGetRange(ctx, task.URL, offset, validator)
+	var downloadErr *DownloadError
+	if offset > 0 && errors.As(err, &downloadErr) && downloadErr.Status == http.StatusRequestedRangeNotSatisfiable {
+		offset = 0
+		resp, err = s.httpGet(ctx, task.URL)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !resumedAt(resp, offset) {
+		offset = 0
+	}
+
+	sums := newChecksums()
+	out, err := openPart(tmp, offset, sums)
+	if err != nil {
+		removePart(tmp)
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	if offset == 0 && task.resumable() {
+		_ = saveResumeState(tmp, task.URL, resp)
+	}
+
+	var w io.Writer = io.MultiWriter(out, sums)
+	if progress != nil {
+		if progress.event.Total == 0 && resp.ContentLength > 0 {
+			progress.event.Total = offset + resp.ContentLength
+		}
+		progress.add(offset)
+		w = io.MultiWriter(out, sums, progress)
+	}
+
+	written, err := io.Copy(w, contextReader{ctx, resp.Body})
+	written += offset
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if progress != nil {
+			progress.rewind()
+		}
+		if !task.resumable() || ctx.Err() != nil {
+			removePart(tmp)
+		}
+		return &DownloadError{URL: task.URL, Cause: err}
+	}
+
+	err = verifyDownload(task, written, sums)
+	if err == nil {
+		err = os.Rename(tmp, task.Dest)
+	}
+	removePart(tmp)
+	if err != nil {
+		if progress != nil {
+			progress.rewind()
+		}
+		return err
+	}
+
+	if progress != nil {
+		progress.finish()
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path so
+// readers never observe a half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Concurrent writers of path each get their own temporary file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.part")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+type checksums struct {
+	sha1   hash.Hash
+	sha256 hash.Hash
+}
+
+func newChecksums() checksums {
+	return checksums{sha1: sha1.New(), sha256: sha256.New()}
+}
+
+func (c checksums) Write(p []byte) (int, error) {
+	c.sha1.Write(p)
+	c.sha256.Write(p)
+	return len(p), nil
+}
+
+func verifyDownload(task downloadTask, written int64, sums checksums) error {
+	if task.Size > 0 && written != task.Size {
+		return &SizeError{Path: task.Dest, Expected: task.Size, Actual: written}
+	}
+	if sum := hex.EncodeToString(sums.sha1.Sum(nil)); task.SHA1 != "" && !strings.EqualFold(sum, task.SHA1) {
+		return &ChecksumError{Path: task.Dest, Expected: task.SHA1, Actual: sum}
+	}
+	if sum := hex.EncodeToString(sums.sha256.Sum(nil)); task.SHA256 != "" && !strings.EqualFold(sum, task.SHA256) {
+		return &ChecksumError{Path: task.Dest, Expected: task.SHA256, Actual: sum}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package piston
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testETag = `"v1"`
+
+func testBody() []byte {
+	body := make([]byte, 64<<10)
+	for i := range body {
+		body[i] = byte(i * 7)
+	}
+	return body
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// requestLog records the Range and If-Range headers of every request a test
+// server receives.
+type requestLog struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (l *requestLog) add(r *http.Request) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, r.Header.Get("Range")+"|"+r.Header.Get("If-Range"))
+	return len(l.requests)
+}
+
+func (l *requestLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.requests...)
+}
+
+// cutTransfer sends the headers for the whole body but only its first half,
+// then drops the connection.
+func cutTransfer(w http.ResponseWriter, body []byte) {
+	w.Header().Set("ETag", testETag)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body[:len(body)/2])
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func testScheduler(t *testing.T, attempts int) *scheduler {
+	t.Helper()
+	return newScheduler(PistonLauncher{
+		BasePath: t.TempDir(),
+		Retry:    RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Millisecond},
+	})
+}
+
+func runDownload(t *testing.T, s *scheduler, task downloadTask) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.run(ctx, PhaseLibraries, []downloadTask{task})
+}
+
+func checkDownloaded(t *testing.T, path string, body []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, body) {
+		t.Fatalf("%s holds %d bytes that differ from the %d served", path, len(data), len(body))
+	}
+	for _, leftover := range []string{path + ".part", resumeStatePath(path + ".part")} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", leftover)
+		}
+	}
+}
+
+func checkRequests(t *testing.T, log *requestLog, want ...string) {
+	t.Helper()
+	got := log.get()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestDownloadResumesCutTransfer(t *testing.T) {
+	body := testBody()
+	var log requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if log.add(r) == 1 {
+			cutTransfer(w, body)
+		}
+		w.Header().Set("ETag", testETag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 2)
+	dest := filepath.Join(s.cacheDir, "file.bin")
+	err := runDownload(t, s, downloadTask{URL: srv.URL + "/file.bin", Dest: dest, SHA1: sha1Hex(body), Size: int64(len(body)), Resumable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkDownloaded(t, dest, body)
+	checkRequests(t, &log, "|", fmt.Sprintf("bytes=%d-|%s", len(body)/2, testETag))
+}
+
+func TestDownloadRestartsWhenRangeIgnored(t *testing.T) {
+	body := testBody()
+	var log requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if log.add(r) == 1 {
+			cutTransfer(w, body)
+		}
+		w.Header().Set("ETag", testETag)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 2)
+	dest := filepath.Join(s.cacheDir, "file.bin")
+	err := runDownload(t, s, downloadTask{URL: srv.URL + "/file.bin", Dest: dest, SHA1: sha1Hex(body), Size: int64(len(body)), Resumable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkDownloaded(t, dest, body)
+	checkRequests(t, &log, "|", fmt.Sprintf("bytes=%d-|%s", len(body)/2, testETag))
+}
+
+func TestDownloadRestartsOnUnsatisfiableRange(t *testing.T) {
+	body := testBody()
+	var log requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r)
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 1)
+	dest := filepath.Join(s.cacheDir, "file.bin")
+	url := srv.URL + "/file.bin"
+
+	// A part left by an earlier run, of a file the server no longer has at
+	// that length.
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err == nil {
+		err = os.WriteFile(dest+".part", bytes.Repeat([]byte{0xff}, 1000), 0644)
+	}
+	if err == nil {
+		err = os.WriteFile(resumeStatePath(dest+".part"), []byte(`{"url":"`+url+`","etag":"\"old\""}`), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runDownload(t, s, downloadTask{URL: url, Dest: dest, SHA1: sha1Hex(body), Size: int64(len(body)), Resumable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkDownloaded(t, dest, body)
+	checkRequests(t, &log, `bytes=1000-|"old"`, "|")
+}
+
+func TestDownloadRetriesChecksumMismatch(t *testing.T) {
+	body := testBody()
+	corrupt := bytes.Clone(body)
+	corrupt[len(corrupt)/3] ^= 0xff
+
+	var log requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if log.add(r) == 1 {
+			w.Write(corrupt)
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 2)
+	dest := filepath.Join(s.cacheDir, "file.bin")
+	task := downloadTask{URL: srv.URL + "/file.bin", Dest: dest, SHA1: sha1Hex(body), Size: int64(len(body))}
+	err := runDownload(t, s, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkDownloaded(t, dest, body)
+	checkRequests(t, &log, "|", "|")
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	body := testBody()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 1)
+	dest := filepath.Join(s.cacheDir, "file.bin")
+	err := runDownload(t, s, downloadTask{URL: srv.URL + "/file.bin", Dest: dest, SHA1: sha1Hex([]byte("other"))})
+
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("err = %v, want a *ChecksumError", err)
+	}
+	if checksumErr.Actual != sha1Hex(body) {
+		t.Errorf("Actual = %s, want %s", checksumErr.Actual, sha1Hex(body))
+	}
+	for _, path := range []string{dest, dest + ".part"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists after a failed download", path)
+		}
+	}
+}
+
+func TestDownloadReplacesStaleFile(t *testing.T) {
+	body := testBody()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := testScheduler(t, 1)
+	dest := filepath.Join(s.cacheDir, "jdk.tar.gz")
+	sum := sha256.Sum256(body)
+	task := downloadTask{URL: srv.URL + "/jdk.tar.gz", Dest: dest, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(body))}
+
+	// A file of the right size that only a SHA-256 tells apart.
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err == nil {
+		err = os.WriteFile(dest, make([]byte, len(body)), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runDownload(t, s, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dest, body)
+}
+
+func TestConcurrentDownloadsShareDestination(t *testing.T) {
+	body := testBody()
+	var log requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r)
+		time.Sleep(50 * time.Millisecond)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	basePath := t.TempDir()
+	dest := filepath.Join(basePath, "libraries", "lib.jar")
+	task := downloadTask{URL: srv.URL + "/lib.jar", Dest: dest, SHA1: sha1Hex(body), Size: int64(len(body))}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		s := newScheduler(PistonLauncher{BasePath: basePath, Retry: RetryPolicy{MaxAttempts: 1}})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runDownload(t, s, task)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkDownloaded(t, dest, body)
+	// The second install waits for the first one and finds the file valid.
+	checkRequests(t, &log, "|")
+}
+
+func TestWriteFileAtomicConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "version.json")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = writeFileAtomic(path, []byte(strconv.Itoa(i)))
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files were left next to %s, want only it", len(entries), path)
+	}
+}
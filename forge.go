@@ -0,0 +1,32 @@
+package piston
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// forgeInstallerURL returns the installer of Forge version forge for
+// Minecraft mcVersion, e.g. 1.20.1 and 47.2.0.
+func forgeInstallerURL(mcVersion string, forge string) (string, string, error) {
+	name := "net.minecraftforge:forge:" + mcVersion + "-" + forge + ":installer"
+	path, err := libraryPathFromName(name)
+	if err != nil {
+		return "", "", err
+	}
+	return "https://" + ForgeMavenHost + "/" + path, path, nil
+}
+
+func (launcher PistonLauncher) DownloadForgeVersion(mcVersion string, forgeVersion string) (*VersionMeta, error) {
+	return launcher.DownloadForgeVersionContext(context.Background(), mcVersion, forgeVersion)
+}
+
+// DownloadForgeVersionContext installs Forge forgeVersion for Minecraft
+// mcVersion (1.13 or later) by running its installer, and returns the
+// version to launch, e.g. 1.20.1-forge-47.2.0.
+func (launcher PistonLauncher) DownloadForgeVersionContext(ctx context.Context, mcVersion string, forgeVersion string) (*VersionMeta, error) {
+	url, path, err := forgeInstallerURL(mcVersion, forgeVersion)
+	if err != nil {
+		return nil, err
+	}
+	return launcher.installWithInstaller(ctx, url, filepath.Join(launcher.BasePath, "libraries", path))
+}
@@ -1,3 +1,5 @@
 module github.com/DeskaDebu/Piston
 
 go 1.24.4
+
+require github.com/ulikunitz/xz v0.5.15
@@ -0,0 +1,420 @@
+package piston
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var tokenPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// installer is an opened Forge-style installer jar: Forge and NeoForge share
+// the format.
+type installer struct {
+	path    string
+	jar     *zip.ReadCloser
+	profile ForgeInstallProfile
+	version VersionMeta
+}
+
+func openInstaller(path string) (*installer, error) {
+	jar, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open installer: %w", err)
+	}
+
+	inst := &installer{path: path, jar: jar}
+	err = inst.readJSON("install_profile.json", &inst.profile)
+	if err == nil && inst.profile.JSON == "" {
+		// Installers for 1.12.2 and older embed the version in the profile
+		// and patch nothing, a format this launcher does not read.
+		err = fmt.Errorf("%w: %s predates install profile spec 0", ErrUnsupportedInstaller, filepath.Base(path))
+	}
+	if err == nil {
+		err = inst.readJSON(inst.profile.JSON, &inst.version)
+	}
+	if err != nil {
+		jar.Close()
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+func (inst *installer) Close() error {
+	return inst.jar.Close()
+}
+
+func (inst *installer) readJSON(name string, v any) error {
+	rc, err := inst.jar.Open(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return fmt.Errorf("%w: %s has no %s", ErrUnsupportedInstaller, filepath.Base(inst.path), name)
+	}
+	defer rc.Close()
+
+	err = json.NewDecoder(rc).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// extract copies the entry name of the installer to dest.
+func (inst *installer) extract(ctx context.Context, name string, dest string) error {
+	rc, err := inst.jar.Open(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return fmt.Errorf("installer has no %s: %w", name, err)
+	}
+	defer rc.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	return writeExtracted(ctx, dest, rc, 0644)
+}
+
+// extractLibraries copies the libraries bundled under maven/ in the installer
+// into the library directory. These are the ones listed without a URL.
+func (inst *installer) extractLibraries(ctx context.Context, baseDir string) error {
+	libraries := filepath.Join(baseDir, "libraries")
+	for _, file := range inst.jar.File {
+		name, ok := strings.CutPrefix(file.Name, "maven/")
+		if !ok || file.FileInfo().IsDir() {
+			continue
+		}
+
+		dest, err := safeJoin(libraries, name)
+		if err != nil {
+			return err
+		}
+		err = inst.extract(ctx, file.Name, dest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processorData resolves the install profile's data for the client side plus
+// the values every installer provides. Entries pointing into the installer are
+// extracted to tmpDir.
+func (inst *installer) processorData(ctx context.Context, baseDir string, minecraftJar string, tmpDir string) (map[string]string, error) {
+	data := map[string]string{
+		"SIDE":              "client",
+		"MINECRAFT_JAR":     minecraftJar,
+		"MINECRAFT_VERSION": inst.profile.Minecraft,
+		"ROOT":              baseDir,
+		"INSTALLER":         inst.path,
+		"LIBRARY_DIR":       filepath.Join(baseDir, "libraries"),
+	}
+
+	for key, entry := range inst.profile.Data {
+		value := entry.Client
+		switch {
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			path, err := libraryPathFromName(value[1 : len(value)-1])
+			if err != nil {
+				return nil, err
+			}
+			data[key] = filepath.Join(baseDir, "libraries", path)
+		case strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'"):
+			data[key] = value[1 : len(value)-1]
+		case strings.HasPrefix(value, "/"):
+			dest, err := safeJoin(tmpDir, value)
+			if err != nil {
+				return nil, err
+			}
+			err = inst.extract(ctx, value, dest)
+			if err != nil {
+				return nil, err
+			}
+			data[key] = dest
+		default:
+			data[key] = value
+		}
+	}
+
+	return data, nil
+}
+
+// resolveProcessorArg expands an argument of a processor: [group:artifact]
+// becomes the library's path, {KEY} the data value and 'text' a literal.
+func resolveProcessorArg(arg string, data map[string]string, baseDir string) (string, error) {
+	if strings.HasPrefix(arg, "[") && strings.HasSuffix(arg, "]") {
+		path, err := libraryPathFromName(arg[1 : len(arg)-1])
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(baseDir, "libraries", path), nil
+	}
+	if strings.HasPrefix(arg, "'") && strings.HasSuffix(arg, "'") {
+		return arg[1 : len(arg)-1], nil
+	}
+
+	var missing string
+	resolved := tokenPattern.ReplaceAllStringFunc(arg, func(match string) string {
+		key := match[1 : len(match)-1]
+		value, ok := data[key]
+		if !ok {
+			missing = key
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("unknown processor data %s in %q", missing, arg)
+	}
+	return resolved, nil
+}
+
+// jarMainClass reads the Main-Class from the manifest of the jar at path.
+func jarMainClass(path string) (string, error) {
+	jar, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer jar.Close()
+
+	rc, err := jar.Open("META-INF/MANIFEST.MF")
+	if err != nil {
+		return "", fmt.Errorf("%s has no manifest: %w", filepath.Base(path), err)
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "Main-Class:"); ok {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no Main-Class", filepath.Base(path))
+}
+
+// processorOutputs resolves the files a processor produces and their
+// expected SHA-1.
+func processorOutputs(proc ForgeProcessor, data map[string]string, baseDir string) (map[string]string, error) {
+	outputs := map[string]string{}
+	for key, value := range proc.Outputs {
+		path, err := resolveProcessorArg(key, data, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := resolveProcessorArg(value, data, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		outputs[path] = sum
+	}
+	return outputs, nil
+}
+
+// runProcessor runs proc with java unless the files it declares are already
+// in place, and checks what it produced.
+func runProcessor(ctx context.Context, java string, proc ForgeProcessor, data map[string]string, baseDir string) error {
+	outputs, err := processorOutputs(proc, data, baseDir)
+	if err != nil {
+		return err
+	}
+
+	done := len(outputs) > 0
+	for path, sum := range outputs {
+		if !fileValid(path, sum, 0) {
+			done = false
+		}
+	}
+	if done {
+		return nil
+	}
+
+	jar, err := resolveProcessorArg("["+proc.Jar+"]", data, baseDir)
+	if err != nil {
+		return err
+	}
+	mainClass, err := jarMainClass(jar)
+	if err != nil {
+		return err
+	}
+
+	classpath := []string{jar}
+	for _, name := range proc.Classpath {
+		path, err := resolveProcessorArg("["+name+"]", data, baseDir)
+		if err != nil {
+			return err
+		}
+		classpath = append(classpath, path)
+	}
+
+	args := []string{"-cp", strings.Join(classpath, string(os.PathListSeparator)), mainClass}
+	for _, arg := range proc.Args {
+		resolved, err := resolveProcessorArg(arg, data, baseDir)
+		if err != nil {
+			return err
+		}
+		args = append(args, resolved)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, java, args...)
+	cmd.Dir = baseDir
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("processor %s failed: %w\n%s", proc.Jar, err, out.String())
+	}
+
+	for path, sum := range outputs {
+		actual, err := fileSum(path, sha1.New)
+		if err != nil {
+			return fmt.Errorf("processor %s did not produce %s: %w", proc.Jar, path, err)
+		}
+		if !strings.EqualFold(actual, sum) {
+			os.Remove(path)
+			return &ChecksumError{Path: path, Expected: sum, Actual: actual}
+		}
+	}
+	return nil
+}
+
+// installerSHA1 returns the SHA-1 the maven publishes next to the installer
+// at url, so that a stale or corrupt jar left by an earlier install is
+// replaced. It returns "" when there is none, leaving the installer to be
+// checked only by opening it.
+func installerSHA1(ctx context.Context, sched *scheduler, url string) (string, error) {
+	if sched.offline {
+		return "", nil
+	}
+
+	data, err := sched.fetchBytes(ctx, url+".sha1")
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		sched.logger.Printf("Not verifying %s: %s", url, err)
+		return "", nil
+	}
+
+	// Some mavens follow the hash with the file name.
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || !validSHA1(fields[0]) {
+		sched.logger.Printf("Not verifying %s: %s.sha1 holds no SHA-1", url, url)
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// installWithInstaller installs the version described by the installer jar
+// at installerURL on top of the vanilla version it inherits from: the client,
+// its libraries and assets, the loader's libraries and those needed by the
+// installer's processors, and then runs the processors with the version's
+// Java runtime. The merged version JSON is written last.
+func (launcher PistonLauncher) installWithInstaller(ctx context.Context, installerURL string, installerPath string) (*VersionMeta, error) {
+	sched := launcher.scheduler()
+
+	startPhase(launcher.Progress, PhaseManifest, 2, 0)
+	sum, err := installerSHA1(ctx, sched, installerURL)
+	if err == nil {
+		err = sched.run(ctx, PhaseManifest, []downloadTask{{URL: installerURL, Dest: installerPath, SHA1: sum, Resumable: true}})
+	}
+	if err != nil {
+		return nil, finishPhase(launcher.Progress, PhaseManifest, err)
+	}
+
+	inst, err := openInstaller(installerPath)
+	if err != nil {
+		// A jar that does not open is most likely truncated or stale.
+		os.Remove(installerPath)
+		return nil, finishPhase(launcher.Progress, PhaseManifest, err)
+	}
+	defer inst.Close()
+
+	parentID := inst.version.InheritsFrom
+	if parentID == "" {
+		parentID = inst.profile.Minecraft
+	}
+	entry, err := findVersion(ctx, sched, parentID)
+	var parent *VersionMeta
+	if err == nil {
+		parent, err = fetchVersionManifest(ctx, sched, entry.URL, entry.SHA1)
+	}
+	if finishPhase(launcher.Progress, PhaseManifest, err) != nil {
+		return nil, err
+	}
+
+	meta := mergeInheritedVersion(parent, &inst.version)
+
+	err = inst.extractLibraries(ctx, launcher.BasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract installer libraries: %w", err)
+	}
+
+	// The version JSON marks the version as installed, so it is only written
+	// once the processors have run. A failed reinstall removes the one left
+	// from before, as the processors may have removed its outputs.
+	versionJSON := filepath.Join(launcher.BasePath, "versions", meta.ID, meta.ID+".json")
+	err = launcher.downloadVersionFiles(ctx, sched, meta)
+	if err == nil {
+		err = downloadLibraries(ctx, sched, &VersionMeta{Libraries: inst.profile.Libraries}, launcher.BasePath)
+	}
+	if err == nil {
+		err = launcher.runProcessors(ctx, inst, meta)
+	}
+	if err == nil {
+		err = saveVersionManifest(meta, launcher.BasePath)
+	}
+	if err != nil {
+		os.Remove(versionJSON)
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (launcher PistonLauncher) runProcessors(ctx context.Context, inst *installer, meta *VersionMeta) error {
+	var processors []ForgeProcessor
+	for _, proc := range inst.profile.Processors {
+		if len(proc.Sides) == 0 || slices.Contains(proc.Sides, "client") {
+			processors = append(processors, proc)
+		}
+	}
+	if len(processors) == 0 {
+		return nil
+	}
+
+	java, err := launcher.javaFor(ctx, meta)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "piston-installer-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	minecraftJar := filepath.Join(launcher.BasePath, "versions", meta.ID, meta.ID+".jar")
+	data, err := inst.processorData(ctx, launcher.BasePath, minecraftJar, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	startPhase(launcher.Progress, PhaseProcessors, len(processors), 0)
+	for i, proc := range processors {
+		launcher.logger().Printf("Running processor %d/%d: %s", i+1, len(processors), proc.Jar)
+		err = runProcessor(ctx, java, proc, data, launcher.BasePath)
+		if err != nil {
+			break
+		}
+	}
+	return finishPhase(launcher.Progress, PhaseProcessors, err)
+}
@@ -0,0 +1,375 @@
+package piston
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func zipBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(content))
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, zipBytes(t, files), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testInstallProfile = `{
+	"spec": 1,
+	"profile": "forge",
+	"version": "1.20.1-forge-47.2.0",
+	"minecraft": "1.20.1",
+	"json": "/version.json",
+	"data": {
+		"MAPPINGS": {"client": "[de.oceanlabs.mcp:mcp_config:1.20.1-20230612.114412:mappings@txt]", "server": "[x:y:1]"},
+		"BINPATCH": {"client": "/data/client.lzma", "server": "/data/server.lzma"},
+		"PATCHED_SHA": {"client": "'0123abcd'", "server": "'ffff'"},
+		"MCP_VERSION": {"client": "20230612.114412", "server": "20230612.114412"}
+	}
+}`
+
+const testInstallerVersion = `{"id": "1.20.1-forge-47.2.0", "inheritsFrom": "1.20.1", "mainClass": "cpw.mods.bootstraplauncher.BootstrapLauncher"}`
+
+func testInstaller(t *testing.T) *installer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forge-installer.jar")
+	writeZip(t, path, map[string]string{
+		"install_profile.json": testInstallProfile,
+		"version.json":         testInstallerVersion,
+		"data/client.lzma":     "client patches",
+	})
+
+	inst, err := openInstaller(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inst.Close() })
+	return inst
+}
+
+func TestOpenInstaller(t *testing.T) {
+	inst := testInstaller(t)
+	if inst.profile.Minecraft != "1.20.1" || len(inst.profile.Data) != 4 {
+		t.Errorf("profile = %+v", inst.profile)
+	}
+	if inst.version.ID != "1.20.1-forge-47.2.0" || inst.version.InheritsFrom != "1.20.1" {
+		t.Errorf("version = %+v", inst.version)
+	}
+}
+
+func TestOpenInstallerUnsupported(t *testing.T) {
+	tests := map[string]map[string]string{
+		// 1.12.2 and older embed the version in the profile.
+		"legacy":     {"install_profile.json": `{"install": {"minecraft": "1.12.2"}, "versionInfo": {"id": "1.12.2-forge"}}`},
+		"no profile": {"version.json": testInstallerVersion},
+		"no version": {"install_profile.json": testInstallProfile},
+	}
+
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "installer.jar")
+			writeZip(t, path, files)
+
+			_, err := openInstaller(path)
+			if !errors.Is(err, ErrUnsupportedInstaller) {
+				t.Errorf("err = %v, want ErrUnsupportedInstaller", err)
+			}
+		})
+	}
+}
+
+func TestProcessorData(t *testing.T) {
+	inst := testInstaller(t)
+	baseDir := t.TempDir()
+	tmpDir := t.TempDir()
+	minecraftJar := filepath.Join(baseDir, "versions", "1.20.1", "1.20.1.jar")
+
+	data, err := inst.processorData(context.Background(), baseDir, minecraftJar, tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	libraries := filepath.Join(baseDir, "libraries")
+	want := map[string]string{
+		"SIDE":              "client",
+		"MINECRAFT_JAR":     minecraftJar,
+		"MINECRAFT_VERSION": "1.20.1",
+		"ROOT":              baseDir,
+		"INSTALLER":         inst.path,
+		"LIBRARY_DIR":       libraries,
+		"MAPPINGS":          filepath.Join(libraries, "de/oceanlabs/mcp/mcp_config/1.20.1-20230612.114412/mcp_config-1.20.1-20230612.114412-mappings.txt"),
+		"BINPATCH":          filepath.Join(tmpDir, "data", "client.lzma"),
+		"PATCHED_SHA":       "0123abcd",
+		"MCP_VERSION":       "20230612.114412",
+	}
+	for key, value := range want {
+		if data[key] != value {
+			t.Errorf("data[%s] = %q, want %q", key, data[key], value)
+		}
+	}
+	if len(data) != len(want) {
+		t.Errorf("data has %d entries, want %d", len(data), len(want))
+	}
+
+	content, err := os.ReadFile(data["BINPATCH"])
+	if err != nil || string(content) != "client patches" {
+		t.Errorf("extracted BINPATCH = %q, %v", content, err)
+	}
+}
+
+func TestResolveProcessorArg(t *testing.T) {
+	baseDir := "base"
+	data := map[string]string{"SIDE": "client", "MINECRAFT_JAR": "mc.jar"}
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"--side", "--side"},
+		{"{SIDE}", "client"},
+		{"--input={MINECRAFT_JAR}:{SIDE}", "--input=mc.jar:client"},
+		{"'{SIDE}'", "{SIDE}"},
+		{"[net.minecraftforge:binarypatcher:1.1.1:fatjar]", filepath.Join(baseDir, "libraries", "net/minecraftforge/binarypatcher/1.1.1/binarypatcher-1.1.1-fatjar.jar")},
+		{"[net.minecraft:client:1.20.1:srg@zip]", filepath.Join(baseDir, "libraries", "net/minecraft/client/1.20.1/client-1.20.1-srg.zip")},
+	}
+	for _, test := range tests {
+		got, err := resolveProcessorArg(test.arg, data, baseDir)
+		if err != nil || got != test.want {
+			t.Errorf("resolveProcessorArg(%q) = %q, %v, want %q", test.arg, got, err, test.want)
+		}
+	}
+
+	for _, arg := range []string{"{MISSING}", "[invalid]"} {
+		_, err := resolveProcessorArg(arg, data, baseDir)
+		if err == nil {
+			t.Errorf("resolveProcessorArg(%q) succeeded", arg)
+		}
+	}
+}
+
+// fakeJava writes a java executable that reports itself as Java 17 when
+// probed. Otherwise it logs its arguments to log and writes the value
+// following --content to the file following --output, failing when the file
+// following --absent exists.
+func fakeJava(t *testing.T, log string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake java is a shell script")
+	}
+
+	java := filepath.Join(t.TempDir(), "java")
+	script := `#!/bin/sh
+if [ "$1" = -XshowSettings:properties ]; then
+	echo "    java.specification.version = 17" >&2
+	exit 0
+fi
+echo "$@" >> '` + log + `'
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--absent) [ -e "$2" ] && exit 3; shift ;;
+	--output) output="$2"; shift ;;
+	--content) content="$2"; shift ;;
+	esac
+	shift
+done
+mkdir -p "$(dirname "$output")"
+printf %s "$content" > "$output"
+`
+	err := os.WriteFile(java, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return java
+}
+
+func TestRunProcessor(t *testing.T) {
+	baseDir := t.TempDir()
+	log := filepath.Join(t.TempDir(), "java.log")
+	java := fakeJava(t, log)
+
+	libraries := filepath.Join(baseDir, "libraries")
+	writeZip(t, filepath.Join(libraries, "net/example/patcher/1.0/patcher-1.0.jar"), map[string]string{
+		"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\nMain-Class: net.example.Patcher\n",
+	})
+
+	output := filepath.Join(baseDir, "patched.jar")
+	data := map[string]string{"PATCHED": output, "PATCHED_SHA": sha1Hex([]byte("patched"))}
+	proc := ForgeProcessor{
+		Jar:       "net.example:patcher:1.0",
+		Classpath: []string{"net.example:util:2.0"},
+		Args:      []string{"--output", "{PATCHED}", "--content", "patched"},
+		Outputs:   map[string]string{"{PATCHED}": "{PATCHED_SHA}"},
+	}
+
+	ctx := context.Background()
+	err := runProcessor(ctx, java, proc, data, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, _ := os.ReadFile(log)
+	classpath := filepath.Join(libraries, "net/example/patcher/1.0/patcher-1.0.jar") + string(os.PathListSeparator) +
+		filepath.Join(libraries, "net/example/util/2.0/util-2.0.jar")
+	wantRun := "-cp " + classpath + " net.example.Patcher --output " + output + " --content patched\n"
+	if string(runs) != wantRun {
+		t.Fatalf("java ran with %q, want %q", runs, wantRun)
+	}
+
+	// The output is in place now, so the processor is skipped.
+	err = runProcessor(ctx, java, proc, data, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, _ = os.ReadFile(log)
+	if strings.Count(string(runs), "\n") != 1 {
+		t.Errorf("processor ran again over valid outputs:\n%s", runs)
+	}
+
+	// A stale output is replaced.
+	err = os.WriteFile(output, []byte("stale"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runProcessor(ctx, java, proc, data, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(output); string(content) != "patched" {
+		t.Errorf("output = %q after rerunning the processor", content)
+	}
+}
+
+func TestRunProcessorChecksumMismatch(t *testing.T) {
+	baseDir := t.TempDir()
+	java := fakeJava(t, filepath.Join(t.TempDir(), "java.log"))
+	writeZip(t, filepath.Join(baseDir, "libraries/net/example/patcher/1.0/patcher-1.0.jar"), map[string]string{
+		"META-INF/MANIFEST.MF": "Main-Class: net.example.Patcher\n",
+	})
+
+	output := filepath.Join(baseDir, "patched.jar")
+	proc := ForgeProcessor{
+		Jar:     "net.example:patcher:1.0",
+		Args:    []string{"--output", output, "--content", "corrupt"},
+		Outputs: map[string]string{"'" + output + "'": "'" + sha1Hex([]byte("patched")) + "'"},
+	}
+
+	err := runProcessor(context.Background(), java, proc, nil, baseDir)
+
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("err = %v, want a *ChecksumError", err)
+	}
+	if checksumErr.Path != output || checksumErr.Actual != sha1Hex([]byte("corrupt")) {
+		t.Errorf("err = %+v", checksumErr)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Error("the mismatching output was kept")
+	}
+}
+
+func TestInstallWithInstaller(t *testing.T) {
+	m := newTestMirror(t)
+	launcher := m.launcher(t)
+	java := fakeJava(t, filepath.Join(t.TempDir(), "java.log"))
+	launcher.JavaPaths = map[uint16]string{17: java}
+
+	parentURL := m.addVersion(t, "1.20.1")
+	manifest, _ := json.Marshal(VersionManifest{Versions: []VersionEntry{{ID: "1.20.1", URL: parentURL}}})
+	m.add(MojangMetaHost, "/mc/game/version_manifest_v2.json", manifest)
+
+	const id = "1.20.1-forge-47.2.0"
+	versionJSON := filepath.Join(launcher.BasePath, "versions", id, id+".json")
+	patched := filepath.Join(launcher.BasePath, "libraries", "net", "example", "patched.jar")
+	installer := func(content string) []byte {
+		profile, _ := json.Marshal(ForgeInstallProfile{
+			Spec:      1,
+			Minecraft: "1.20.1",
+			JSON:      "/version.json",
+			Data:      map[string]ForgeDataEntry{"PATCHED_SHA": {Client: "'" + sha1Hex([]byte("patched")) + "'"}},
+			Processors: []ForgeProcessor{{
+				Jar: "net.example:patcher:1.0",
+				// The processors run before the version counts as installed.
+				Args:    []string{"--absent", versionJSON, "--output", patched, "--content", content},
+				Outputs: map[string]string{"'" + patched + "'": "{PATCHED_SHA}"},
+			}},
+		})
+		patcher := zipBytes(t, map[string]string{"META-INF/MANIFEST.MF": "Main-Class: net.example.Patcher\n"})
+		return zipBytes(t, map[string]string{
+			"install_profile.json": string(profile),
+			"version.json":         `{"id": "` + id + `", "inheritsFrom": "1.20.1", "mainClass": "cpw.mods.bootstraplauncher.BootstrapLauncher", "javaVersion": {"majorVersion": 17}}`,
+			"maven/net/example/patcher/1.0/patcher-1.0.jar": string(patcher),
+		})
+	}
+
+	installerURL, installerPath, err := forgeInstallerURL("1.20.1", "47.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish := func(content string) {
+		data := installer(content)
+		m.add(ForgeMavenHost, "/"+installerPath, data)
+		m.add(ForgeMavenHost, "/"+installerPath+".sha1", []byte(sha1Hex(data)))
+	}
+	publish("corrupt")
+	ctx := context.Background()
+
+	// A processor producing the wrong output fails the install.
+	_, err = launcher.installWithInstaller(ctx, installerURL, filepath.Join(launcher.BasePath, "libraries", installerPath))
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("err = %v, want a *ChecksumError", err)
+	}
+	if launcher.IsVersionInstalled(id) {
+		t.Fatal("the version is installed although its processor failed")
+	}
+
+	// The installer left from the failed install opens fine, but no longer
+	// matches the published SHA-1 and is replaced.
+	publish("patched")
+	meta, err := launcher.DownloadForgeVersionContext(ctx, "1.20.1", "47.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != id || !launcher.IsVersionInstalled(id) {
+		t.Fatalf("%s is not installed", id)
+	}
+	if content, _ := os.ReadFile(patched); string(content) != "patched" {
+		t.Errorf("processor output = %q", content)
+	}
+
+	installed, err := loadVersionManifest(launcher.BasePath, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed.MainClass != "cpw.mods.bootstraplauncher.BootstrapLauncher" || installed.AssetIndex.ID != "1.20.1" {
+		t.Errorf("installed version JSON is not the merged version: %+v", installed)
+	}
+}
@@ -0,0 +1,178 @@
+package piston
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	instanceSettingsFile = "instance.json"
+	instanceGameDir      = "minecraft"
+)
+
+// InstanceSettings are the launch settings stored with an instance.
+type InstanceSettings struct {
+	Version    string            `json:"version"`
+	MinMemory  uint32            `json:"minMemory,omitempty"`
+	MaxMemory  uint32            `json:"maxMemory,omitempty"`
+	JVMArgs    []string          `json:"jvmArgs,omitempty"`
+	GameArgs   []string          `json:"gameArgs,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Width      int               `json:"width,omitempty"`
+	Height     int               `json:"height,omitempty"`
+	Fullscreen bool              `json:"fullscreen,omitempty"`
+	JavaPath   string            `json:"javaPath,omitempty"`
+}
+
+// Instance is an isolated game directory under BasePath/instances/<name>
+// with its own saves, mods, options and resource packs. Dir holds the
+// instance's settings and GameDir the game's own files, so that nothing the
+// game writes can collide with them. Versions, libraries, assets and Java
+// runtimes stay shared between instances.
+type Instance struct {
+	Name     string
+	Dir      string
+	Settings InstanceSettings
+}
+
+func validInstanceName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\:`) && filepath.Base(name) == name
+}
+
+func (launcher PistonLauncher) instanceDir(name string) (string, error) {
+	if !validInstanceName(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidInstanceName, name)
+	}
+	return filepath.Join(launcher.BasePath, "instances", name), nil
+}
+
+// CreateInstance creates a new instance called name.
+func (launcher PistonLauncher) CreateInstance(name string, settings InstanceSettings) (*Instance, error) {
+	dir, err := launcher.instanceDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if pathExists(dir) {
+		return nil, fmt.Errorf("%w: %s", ErrInstanceExists, name)
+	}
+
+	instance := &Instance{Name: name, Dir: dir, Settings: settings}
+	err = os.MkdirAll(instance.GameDir(), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance directory: %w", err)
+	}
+
+	err = instance.Save()
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (launcher PistonLauncher) Instance(name string) (*Instance, error) {
+	dir, err := launcher.instanceDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, instanceSettingsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance %s: %w", name, err)
+	}
+
+	instance := &Instance{Name: name, Dir: dir}
+	err = json.Unmarshal(data, &instance.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse instance %s: %w", name, err)
+	}
+	return instance, nil
+}
+
+func (launcher PistonLauncher) Instances() ([]*Instance, error) {
+	entries, err := os.ReadDir(filepath.Join(launcher.BasePath, "instances"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instances directory: %w", err)
+	}
+
+	var instances []*Instance
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		instance, err := launcher.Instance(entry.Name())
+		if errors.Is(err, ErrInstanceNotFound) || errors.Is(err, ErrInvalidInstanceName) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// DeleteInstance removes an instance together with its saves and mods.
+func (launcher PistonLauncher) DeleteInstance(name string) error {
+	dir, err := launcher.instanceDir(name)
+	if err != nil {
+		return err
+	}
+	if !fileExists(filepath.Join(dir, instanceSettingsFile)) {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, name)
+	}
+	return os.RemoveAll(dir)
+}
+
+// Save writes the instance's settings.
+func (instance *Instance) Save() error {
+	data, err := json.MarshalIndent(instance.Settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(instance.Dir, instanceSettingsFile), data)
+}
+
+// GameDir is the game directory of the instance, where its saves, mods and
+// options live.
+func (instance *Instance) GameDir() string {
+	return filepath.Join(instance.Dir, instanceGameDir)
+}
+
+// LaunchOptions returns the options that launch the instance for account.
+func (instance *Instance) LaunchOptions(account Account) LaunchOptions {
+	settings := instance.Settings
+	return LaunchOptions{
+		Account:    account,
+		MinMemory:  settings.MinMemory,
+		MaxMemory:  settings.MaxMemory,
+		JVMArgs:    settings.JVMArgs,
+		GameArgs:   settings.GameArgs,
+		Env:        settings.Env,
+		GameDir:    instance.GameDir(),
+		Width:      settings.Width,
+		Height:     settings.Height,
+		Fullscreen: settings.Fullscreen,
+		JavaPath:   settings.JavaPath,
+	}
+}
+
+// LaunchInstance starts the instance's version in its own game directory.
+func (launcher PistonLauncher) LaunchInstance(ctx context.Context, instance *Instance, account Account) (*GameProcess, error) {
+	if instance.Settings.Version == "" {
+		return nil, fmt.Errorf("instance %s has no version", instance.Name)
+	}
+	return launcher.Launch(ctx, instance.Settings.Version, instance.LaunchOptions(account))
+}
@@ -0,0 +1,34 @@
+package piston
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstanceGameDirIsSeparateFromSettings(t *testing.T) {
+	launcher := PistonLauncher{BasePath: t.TempDir()}
+	instance, err := launcher.CreateInstance("modded", InstanceSettings{Version: "1.21"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := instance.LaunchOptions(Account{})
+	if want := filepath.Join(instance.Dir, "minecraft"); opts.GameDir != want {
+		t.Errorf("GameDir = %s, want %s", opts.GameDir, want)
+	}
+	if info, err := os.Stat(opts.GameDir); err != nil || !info.IsDir() {
+		t.Errorf("the game directory was not created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.GameDir, instanceSettingsFile)); !os.IsNotExist(err) {
+		t.Errorf("the settings are inside the game directory: %v", err)
+	}
+
+	loaded, err := launcher.Instance("modded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GameDir() != opts.GameDir {
+		t.Errorf("reloaded GameDir = %s, want %s", loaded.GameDir(), opts.GameDir)
+	}
+}
@@ -0,0 +1,77 @@
+package piston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// JavaUpdate pairs an installed runtime with the newer release available
+// for it.
+type JavaUpdate struct {
+	Runtime JavaRuntime
+	Latest  string
+}
+
+func (launcher PistonLauncher) javaUpdater() (JavaUpdater, error) {
+	updater, ok := launcher.javaProvider().(JavaUpdater)
+	if !ok {
+		return nil, fmt.Errorf("%T cannot manage runtimes: %w", launcher.javaProvider(), errors.ErrUnsupported)
+	}
+	return updater, nil
+}
+
+// InstalledJava lists the runtimes installed by the launcher's JavaProvider
+// together with their exact releases.
+func (launcher PistonLauncher) InstalledJava() ([]JavaRuntime, error) {
+	updater, err := launcher.javaUpdater()
+	if err != nil {
+		return nil, err
+	}
+	return updater.Installed(&launcher)
+}
+
+// CheckJavaUpdates asks the provider, bypassing the manifest cache, for the
+// latest release of every installed runtime and returns those that are out
+// of date.
+func (launcher PistonLauncher) CheckJavaUpdates(ctx context.Context) ([]JavaUpdate, error) {
+	updater, err := launcher.javaUpdater()
+	if err != nil {
+		return nil, err
+	}
+
+	runtimes, err := updater.Installed(&launcher)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []JavaUpdate
+	for _, runtime := range runtimes {
+		latest, err := updater.Latest(ctx, &launcher, runtime.Java)
+		if err != nil {
+			return updates, fmt.Errorf("failed to check Java %d: %w", runtime.Java.MajorVersion, err)
+		}
+		if latest != runtime.Release {
+			updates = append(updates, JavaUpdate{Runtime: runtime, Latest: latest})
+		}
+	}
+
+	return updates, nil
+}
+
+// UpgradeJava updates the runtime for the given Java major version to its
+// latest release. AdoptiumProvider and MojangRuntimeProvider install the new
+// release side by side and swap it in, so the current one stays usable if the
+// upgrade fails.
+func (launcher PistonLauncher) UpgradeJava(ctx context.Context, version uint16) error {
+	updater, err := launcher.javaUpdater()
+	if err != nil {
+		return err
+	}
+	return updater.Upgrade(ctx, &launcher, JavaVersion{MajorVersion: version})
+}
+
+// UninstallJava removes the runtime for the given Java major version.
+func (launcher PistonLauncher) UninstallJava(version uint16) error {
+	return launcher.javaProvider().Remove(&launcher, JavaVersion{MajorVersion: version})
+}
@@ -0,0 +1,130 @@
+package piston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// JavaProvider installs and locates the Java runtimes used to launch the game.
+type JavaProvider interface {
+	// Find returns the java executable of an installed runtime satisfying
+	// java, or "" when there is none.
+	Find(launcher *PistonLauncher, java JavaVersion) string
+	// Install downloads the runtime for java.
+	Install(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error
+	// Remove deletes the runtime for java so that it can be reinstalled.
+	Remove(launcher *PistonLauncher, java JavaVersion) error
+}
+
+// JavaUpdater is implemented by providers that can list the runtimes they
+// installed and update them to newer releases.
+type JavaUpdater interface {
+	// Installed lists the complete runtimes installed by the provider.
+	Installed(launcher *PistonLauncher) ([]JavaRuntime, error)
+	// Latest returns the newest release available for java.
+	Latest(ctx context.Context, launcher *PistonLauncher, java JavaVersion) (string, error)
+	// Upgrade replaces the runtime for java with the latest release.
+	Upgrade(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error
+}
+
+// JavaRuntime is a runtime installed by a JavaProvider.
+type JavaRuntime struct {
+	Java JavaVersion
+	// Release is the exact release installed, e.g. "jdk-21.0.3+9".
+	Release string
+	Path    string
+}
+
+// AdoptiumProvider installs Eclipse Temurin JDKs from the Adoptium API into
+// BasePath/java/jdk-<major>. It is the default provider.
+type AdoptiumProvider struct{}
+
+func (AdoptiumProvider) Find(launcher *PistonLauncher, java JavaVersion) string {
+	dir := jdkDir(launcher.BasePath, java.MajorVersion)
+	if !fileExists(filepath.Join(dir, runtimeMarker)) {
+		return ""
+	}
+	return findJavaExecutable(dir)
+}
+
+func (AdoptiumProvider) Install(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error {
+	return downloadJDK(ctx, launcher.scheduler(), launcher.BasePath, java.MajorVersion)
+}
+
+func (AdoptiumProvider) Remove(launcher *PistonLauncher, java JavaVersion) error {
+	return os.RemoveAll(jdkDir(launcher.BasePath, java.MajorVersion))
+}
+
+func (AdoptiumProvider) Installed(launcher *PistonLauncher) ([]JavaRuntime, error) {
+	return installedJDKs(launcher.BasePath)
+}
+
+func (AdoptiumProvider) Latest(ctx context.Context, launcher *PistonLauncher, java JavaVersion) (string, error) {
+	release, err := fetchAdoptiumRelease(ctx, launcher.scheduler(), java.MajorVersion, 0)
+	if err != nil {
+		return "", err
+	}
+	return release.ReleaseName, nil
+}
+
+// Upgrade installs the latest GA build next to the current JDK and swaps it
+// in once it is complete.
+func (AdoptiumProvider) Upgrade(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error {
+	sched := launcher.scheduler()
+	release, err := fetchAdoptiumRelease(ctx, sched, java.MajorVersion, 0)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(filepath.Join(jdkDir(launcher.BasePath, java.MajorVersion), runtimeMarker))
+	if err == nil && string(current) == release.ReleaseName {
+		return nil
+	}
+
+	return upgradeJDK(ctx, sched, launcher.BasePath, java.MajorVersion, release)
+}
+
+func (launcher PistonLauncher) javaProvider() JavaProvider {
+	if launcher.JavaProvider != nil {
+		return launcher.JavaProvider
+	}
+	return AdoptiumProvider{}
+}
+
+// VerifyJava checks that the runtime the provider installed for version
+// exists and actually runs, reporting ErrJavaBroken when it does not.
+func (launcher PistonLauncher) VerifyJava(version uint16) error {
+	return launcher.verifyJava(context.Background(), JavaVersion{MajorVersion: version})
+}
+
+func (launcher PistonLauncher) verifyJava(ctx context.Context, java JavaVersion) error {
+	path := launcher.javaProvider().Find(&launcher, java)
+	if path == "" {
+		return fmt.Errorf("%w: Java %d is not installed", ErrJavaNotFound, java.MajorVersion)
+	}
+	return verifyJavaAt(ctx, path, java.MajorVersion)
+}
+
+// verifyJavaAt runs the java executable at path and checks that it reports
+// the expected major version. Successful checks are remembered by
+// probeJavaCached until the executable changes. A JVM that is merely slow to
+// start is not reported as broken, so that it is not reinstalled.
+func verifyJavaAt(ctx context.Context, path string, major uint16) error {
+	install, err := probeJavaCached(ctx, path)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("failed to verify Java at %s: %w", path, err)
+		}
+		return fmt.Errorf("%w: %s: %w", ErrJavaBroken, path, err)
+	}
+	if install.Major != major {
+		return fmt.Errorf("%w: %s reports Java %d, expected %d", ErrJavaBroken, path, install.Major, major)
+	}
+	return nil
+}
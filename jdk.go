@@ -1,126 +1,219 @@
 package piston
 
 import (
-	"archive/zip"
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func downloadJDK(baseDir string, version uint16) error {
+// adoptiumOS and adoptiumArch translate Go's platform names into the ones
+// used by the Adoptium API.
+func adoptiumOS() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "mac"
+	default:
+		return runtime.GOOS
+	}
+}
+
+func adoptiumArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x64"
+	case "386":
+		return "x32"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+func jdkDir(baseDir string, version uint16) string {
+	return filepath.Join(baseDir, "java", "jdk-"+fmt.Sprint(version))
+}
+
+// fetchAdoptiumRelease returns the latest GA Temurin JDK build of version
+// for this platform, including its SHA-256.
+func fetchAdoptiumRelease(ctx context.Context, sched *scheduler, version uint16, ttl time.Duration) (*AdoptiumRelease, error) {
 	url := fmt.Sprintf(
-		"https://api.adoptium.net/v3/binary/latest/%d/ga/windows/x64/jdk/hotspot/normal/eclipse",
-		version,
+		"https://%s/v3/assets/latest/%d/hotspot?architecture=%s&image_type=jdk&os=%s&vendor=eclipse",
+		AdoptiumAPIHost, version, adoptiumArch(), adoptiumOS(),
 	)
 
-	jdkFolder := filepath.Join(baseDir, "java", "jdk-"+fmt.Sprint(version))
-	if _, err := os.Stat(jdkFolder); err == nil {
-		log.Printf("JDK %d already downloaded", version)
-		return nil
+	var releases []AdoptiumRelease
+	err := sched.fetchCachedJSON(ctx, url, ttl, &releases)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%w: no Adoptium build of Java %d for %s/%s", ErrJavaNotFound, version, adoptiumOS(), adoptiumArch())
 	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to fetch JDK: %w", err)
+	return &releases[0], nil
+}
+
+func downloadJDK(ctx context.Context, sched *scheduler, baseDir string, version uint16) error {
+	jdkFolder := jdkDir(baseDir, version)
+	if fileExists(filepath.Join(jdkFolder, runtimeMarker)) {
+		sched.logger.Printf("JDK %d already downloaded", version)
+		return nil
 	}
-	defer resp.Body.Close()
 
-	zipPath := filepath.Join(baseDir, "java-temp", "jdk-" + fmt.Sprint(version) + ".zip")
-	err = os.MkdirAll(filepath.Dir(zipPath), 0755)
+	release, err := fetchAdoptiumRelease(ctx, sched, version, sched.manifestTTL)
 	if err != nil {
-		return fmt.Errorf("failed to create java dir: %w", err)
+		return err
 	}
 
-	outFile, err := os.Create(zipPath)
+	startPhase(sched.progress, PhaseJDK, 1, release.Binary.Package.Size)
+	err = installJDK(ctx, sched, release, baseDir, jdkFolder)
+	return finishPhase(sched.progress, PhaseJDK, err)
+}
+
+// installJDK downloads and verifies release and extracts it into jdkFolder,
+// replacing whatever was there. The install marker is written last, so a
+// folder without one is the leftover of an interrupted install.
+func installJDK(ctx context.Context, sched *scheduler, release *AdoptiumRelease, baseDir string, jdkFolder string) error {
+	err := os.RemoveAll(jdkFolder)
 	if err != nil {
-		return fmt.Errorf("failed to create JDK file: %w", err)
+		return fmt.Errorf("failed to clear %s: %w", jdkFolder, err)
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, resp.Body)
+	pkg := release.Binary.Package
+	archivePath := filepath.Join(baseDir, "java-temp", filepath.Base(pkg.Name))
+	err = sched.run(ctx, PhaseJDK, []downloadTask{{
+		URL:       pkg.Link,
+		Dest:      archivePath,
+		SHA256:    pkg.Checksum,
+		Size:      pkg.Size,
+		Resumable: true,
+	}})
 	if err != nil {
-		return fmt.Errorf("failed to save JDK: %w", err)
+		return fmt.Errorf("failed to fetch JDK: %w", err)
 	}
 
-	err = unzip(zipPath, jdkFolder)
+	err = extractArchive(ctx, archivePath, jdkFolder)
+	_ = os.Remove(archivePath)
+	if err == nil && findJavaExecutable(jdkFolder) == "" {
+		err = fmt.Errorf("%w in %s", ErrJavaNotFound, pkg.Name)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(jdkFolder, runtimeMarker), []byte(release.ReleaseName), 0644)
+	}
 	if err != nil {
-    	return fmt.Errorf("failed to unzip JDK: %w", err)
+		_ = os.RemoveAll(jdkFolder)
+		return fmt.Errorf("failed to extract JDK: %w", err)
 	}
 
-	_ = os.Remove(zipPath)
-
 	return nil
 }
 
-func unzip(src string, dest string) error {
-	r, err := zip.OpenReader(src)
+// upgradeJDK installs release next to the current JDK of version and swaps
+// it in once complete, so the old runtime stays usable until the new one is.
+func upgradeJDK(ctx context.Context, sched *scheduler, baseDir string, version uint16, release *AdoptiumRelease) error {
+	jdkFolder := jdkDir(baseDir, version)
+	staging := jdkFolder + ".new"
+
+	startPhase(sched.progress, PhaseJDK, 1, release.Binary.Package.Size)
+	err := installJDK(ctx, sched, release, baseDir, staging)
+	if err == nil {
+		err = replaceDir(staging, jdkFolder)
+	}
+	return finishPhase(sched.progress, PhaseJDK, err)
+}
+
+// replaceDir moves src to dest, putting the previous dest back if that fails.
+func replaceDir(src string, dest string) error {
+	old := dest + ".old"
+	err := os.RemoveAll(old)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-		if !strings.HasPrefix(fpath, filepath.Clean(dest) + string(os.PathSeparator)) {
-			return fmt.Errorf("illegal file path: %s", fpath)
-		}
+	err = os.Rename(dest, old)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move %s aside: %w", dest, err)
+	}
 
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
-		}
+	err = os.Rename(src, dest)
+	if err != nil {
+		os.Rename(old, dest)
+		os.RemoveAll(src)
+		return fmt.Errorf("failed to replace %s: %w", dest, err)
+	}
 
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
+	os.RemoveAll(old)
+	return nil
+}
 
-		in, err := f.Open()
-		if err != nil {
-			return err
-		}
+// installedJDKs lists the complete installs under baseDir/java.
+func installedJDKs(baseDir string) ([]JavaRuntime, error) {
+	entries, err := os.ReadDir(filepath.Join(baseDir, "java"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		out, err := os.Create(fpath)
-		if err != nil {
-			in.Close()
-			return err
+	var runtimes []JavaRuntime
+	for _, entry := range entries {
+		major, err := strconv.ParseUint(strings.TrimPrefix(entry.Name(), "jdk-"), 10, 16)
+		if err != nil || !entry.IsDir() {
+			continue
 		}
 
-		_, err = io.Copy(out, in)
-
-		in.Close()
-		out.Close()
-
+		dir := filepath.Join(baseDir, "java", entry.Name())
+		release, err := os.ReadFile(filepath.Join(dir, runtimeMarker))
 		if err != nil {
-			return err
+			continue
 		}
+		runtimes = append(runtimes, JavaRuntime{
+			Java:    JavaVersion{MajorVersion: uint16(major)},
+			Release: string(release),
+			Path:    findJavaExecutable(dir),
+		})
 	}
-	return nil
+
+	return runtimes, nil
 }
 
-func findJavaExecutable(jdkDir string) string {
-	javaPath := filepath.Join(jdkDir, "bin", "java.exe")
-	if _, err := os.Stat(javaPath); err == nil {
-		return javaPath
+func javaExecutableName() string {
+	if runtime.GOOS == "windows" {
+		return "java.exe"
 	}
+	return "java"
+}
 
+// findJavaExecutable looks for bin/java in jdkDir or in one of its immediate
+// subdirectories, which is where Adoptium archives put the JDK, including the
+// Contents/Home layout of macOS bundles. It returns "" when none is found.
+func findJavaExecutable(jdkDir string) string {
+	candidates := []string{jdkDir}
 	files, err := os.ReadDir(jdkDir)
 	if err != nil {
-		fmt.Println("JDK directory read failed:", err)
 		return ""
 	}
 	for _, f := range files {
 		if f.IsDir() {
-			javaPath := filepath.Join(jdkDir, f.Name(), "bin", "java.exe")
-			if _, err := os.Stat(javaPath); err == nil {
+			candidates = append(candidates, filepath.Join(jdkDir, f.Name()))
+		}
+	}
+
+	for _, dir := range candidates {
+		for _, home := range []string{dir, filepath.Join(dir, "Contents", "Home")} {
+			javaPath := filepath.Join(home, "bin", javaExecutableName())
+			if info, err := os.Stat(javaPath); err == nil && !info.IsDir() {
 				return javaPath
 			}
 		}
 	}
-	fmt.Println("Could not find java.exe in:", jdkDir)
 	return ""
 }
 
@@ -129,18 +222,13 @@ func pathExists(path string) bool {
     return err == nil
 }
 
-func requiresJDK8(minecraftVersion string) bool {
-    var major, minor int
-    n, err := fmt.Sscanf(minecraftVersion, "%d.%d", &major, &minor)
-    if err != nil || n < 2 {
-        return true
-    }
-
-    if major < 1 {
-        return true
-    }
-    if major == 1 && minor < 12 {
-        return true
-    }
-    return false
-}
\ No newline at end of file
+// Version JSONs older than the javaVersion field all target Java 8.
+const legacyJavaMajor = 8
+
+// javaMajor returns the Java major version a game version asks for.
+func javaMajor(meta *VersionMeta) uint16 {
+	if meta.JavaVersion == nil || meta.JavaVersion.MajorVersion == 0 {
+		return legacyJavaMajor
+	}
+	return meta.JavaVersion.MajorVersion
+}
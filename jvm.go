@@ -10,19 +10,6 @@ import (
 
 var varPattern = regexp.MustCompile(`\$\{([a-zA-Z0-9_]+)\}`)
 
-var ignoredArgs = map[string]bool{
-	"-Dminecraft.launcher.brand=": true,
-	"-Dminecraft.launcher.version=": true,
-	"--quickPlayPath":        true,
-	"--quickPlaySingleplayer": true,
-	"--quickPlayMultiplayer":  true,
-	"--quickPlayRealms":       true,
-	"--width": true,
-	"--height": true,
-	"--demo": true,
-	"--xuid": true,
-}
-
 func replaceVars(s string, vars map[string]string) string {
 	return varPattern.ReplaceAllStringFunc(s, func(match string) string {
 		key := varPattern.FindStringSubmatch(match)[1]
@@ -33,38 +20,43 @@ func replaceVars(s string, vars map[string]string) string {
 	})
 }
 
-func expandArguments(args []Argument, replacements map[string]string) []string {
+// expandArguments substitutes vars into the arguments whose rules allow them
+// in env. An argument left with an unknown variable is dropped, and so is the
+// flag it is the value of: the literal "-" argument right before it, unless
+// the unresolved argument is a flag itself, such as -Dname=${value}.
+func expandArguments(args []Argument, replacements map[string]string, env Environment) []string {
 	var result []string
+	lastIsFlag := false
 	for _, arg := range args {
-		if !isAllowed(arg.Rules) {
+		if !env.Allows(arg.Rules) {
 			continue
 		}
-		for i := 0; i < len(arg.Value); i++ {
-			val := replaceVars(arg.Value[i], replacements)
-			
-			if ignoredArgs[val] {
-				continue
-			}
+		for _, template := range arg.Value {
+			val := replaceVars(template, replacements)
 
 			if strings.Contains(val, "${") {
+				if lastIsFlag && !strings.HasPrefix(template, "-") {
+					result = result[:len(result)-1]
+				}
+				lastIsFlag = false
 				continue
 			}
 
 			result = append(result, val)
+			lastIsFlag = strings.HasPrefix(template, "-") && !strings.Contains(template, "${")
 		}
 	}
 	return result
 }
 
-
-func buildClasspath(meta *VersionMeta, baseDir string) string {
+func buildClasspath(meta *VersionMeta, baseDir string, env Environment) (string, error) {
 	var paths []string
 
 	clientJar := filepath.Join(baseDir, "versions", meta.ID, meta.ID+".jar")
 	paths = append(paths, clientJar)
 
 	for _, lib := range meta.Libraries {
-		if !isAllowed(lib.Rules) {
+		if !env.Allows(lib.Rules) || nativesClassifierOf(lib.Name) != "" {
 			continue
 		}
 		
@@ -73,40 +65,71 @@ func buildClasspath(meta *VersionMeta, baseDir string) string {
 			continue
 		}
 		
-		libPath := filepath.Join(baseDir, "libraries", libraryPathFromName(lib.Name))
-		paths = append(paths, libPath)
+		libPath, err := libraryPathFromName(lib.Name)
+		if err != nil {
+			return "", err
+		}
+		paths = append(paths, filepath.Join(baseDir, "libraries", libPath))
 	}
 
-	return strings.Join(paths, string(os.PathListSeparator))
+	return strings.Join(paths, string(os.PathListSeparator)), nil
 }
 
-func buildLaunchCommand(meta *VersionMeta, baseDir string, vars map[string]string, xmx uint32) []string {
-	classpath := buildClasspath(meta, baseDir)
-	vars["classpath"] = classpath
-	vars["natives_directory"] = filepath.Join(baseDir, "natives", meta.ID)
-
+func memoryArgs(opts LaunchOptions) []string {
+	var args []string
+	if opts.MinMemory > 0 {
+		args = append(args, fmt.Sprintf("-Xms%dm", opts.MinMemory))
+	}
+	if opts.MaxMemory > 0 {
+		args = append(args, fmt.Sprintf("-Xmx%dm", opts.MaxMemory))
+	}
+	return args
+}
 
-	jvmArgs := []string{
-		fmt.Sprintf("-Xmx%dm", xmx),
-		"-Djava.library.path=" + vars["natives_directory"],
-		"-cp", classpath,
+func buildLaunchCommand(meta *VersionMeta, baseDir string, natives string, vars map[string]string, opts LaunchOptions, env Environment) ([]string, error) {
+	env = env.withFeatures(opts.features())
+	classpath, err := buildClasspath(meta, baseDir, env)
+	if err != nil {
+		return nil, err
 	}
+	vars["classpath"] = classpath
+	vars["natives_directory"] = natives
+	vars["library_directory"] = filepath.Join(baseDir, "libraries")
+	vars["classpath_separator"] = string(os.PathListSeparator)
+	libraryPath := nativeLibraryPath(vars["natives_directory"])
+
+	jvmArgs := memoryArgs(opts)
 
 	if meta.Arguments.Game == nil || len(meta.Arguments.Game) == 0 {
-		mainClass := meta.MainClass
-		jvmArgs = append(jvmArgs, mainClass)
+		jvmArgs = append(jvmArgs,
+			"-Djava.library.path="+libraryPath,
+			"-cp", classpath,
+		)
+		jvmArgs = append(jvmArgs, opts.JVMArgs...)
+		jvmArgs = append(jvmArgs, meta.MainClass)
 
 		mcArgsStr := replaceVars(meta.OlderArguments, vars)
 		gameArgs := strings.Fields(mcArgsStr)
+		gameArgs = append(gameArgs, opts.legacyWindowArgs()...)
+		gameArgs = append(gameArgs, opts.GameArgs...)
 
-		return append(jvmArgs, gameArgs...)
+		return append(jvmArgs, gameArgs...), nil
 	}
-	
-	jvmArgs = expandArguments(meta.Arguments.JVM, vars)
-	jvmArgs = append([]string{fmt.Sprintf("-Xmx%dm", xmx)}, jvmArgs...)
+
+	for _, arg := range expandArguments(meta.Arguments.JVM, vars, env) {
+		if arg == "-Djava.library.path="+vars["natives_directory"] {
+			arg = "-Djava.library.path=" + libraryPath
+		}
+		jvmArgs = append(jvmArgs, arg)
+	}
+	jvmArgs = append(jvmArgs, opts.JVMArgs...)
 	jvmArgs = append(jvmArgs, meta.MainClass)
 
-	gameArgs := expandArguments(meta.Arguments.Game, vars)
+	gameArgs := expandArguments(meta.Arguments.Game, vars, env)
+	if opts.Fullscreen {
+		gameArgs = append(gameArgs, "--fullscreen")
+	}
+	gameArgs = append(gameArgs, opts.GameArgs...)
 
-	return append(jvmArgs, gameArgs...)
-}
\ No newline at end of file
+	return append(jvmArgs, gameArgs...), nil
+}
@@ -0,0 +1,108 @@
+package piston
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func parseArguments(t *testing.T, data string) []Argument {
+	t.Helper()
+	var args []Argument
+	err := json.Unmarshal([]byte(data), &args)
+	if err != nil {
+		t.Fatalf("invalid arguments %s: %v", data, err)
+	}
+	return args
+}
+
+func TestExpandArguments(t *testing.T) {
+	vars := map[string]string{"auth_player_name": "Steve", "dashed": "--demo", "version_name": "1.21"}
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{`["--username", "${auth_player_name}", "--xuid", "${auth_xuid}", "--version", "${version_name}"]`,
+			[]string{"--username", "Steve", "--version", "1.21"}},
+		// A resolved value that looks like a flag is not dropped with the
+		// unresolved argument after it.
+		{`["--username", "${dashed}", "${auth_xuid}"]`,
+			[]string{"--username", "--demo"}},
+		// An unresolved JVM flag is dropped on its own.
+		{`["--enable-native-access=ALL-UNNAMED", "-Dlog4j.configurationFile=${path}", "-cp", "${classpath}"]`,
+			[]string{"--enable-native-access=ALL-UNNAMED"}},
+		{`[{"rules": [{"action": "allow", "features": {"has_custom_resolution": true}}], "value": ["--width", "${resolution_width}"]}]`,
+			nil},
+	}
+
+	env := Environment{OS: "linux", Arch: "x86_64", Features: map[string]bool{"has_custom_resolution": true}}
+	for _, test := range tests {
+		got := expandArguments(parseArguments(t, test.args), vars, env)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("expandArguments(%s) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+const testFeatureArguments = `{
+	"game": [
+		"--username", "${auth_player_name}",
+		"--version", "${version_name}",
+		"--xuid", "${auth_xuid}",
+		{"rules": [{"action": "allow", "features": {"is_demo_user": true}}], "value": "--demo"},
+		{"rules": [{"action": "allow", "features": {"has_custom_resolution": true}}], "value": ["--width", "${resolution_width}", "--height", "${resolution_height}"]},
+		{"rules": [{"action": "allow", "features": {"has_quick_plays_support": true}}], "value": ["--quickPlayPath", "${quickPlayPath}"]},
+		{"rules": [{"action": "allow", "features": {"is_quick_play_singleplayer": true}}], "value": ["--quickPlaySingleplayer", "${quickPlaySingleplayer}"]},
+		{"rules": [{"action": "allow", "features": {"is_quick_play_multiplayer": true}}], "value": ["--quickPlayMultiplayer", "${quickPlayMultiplayer}"]},
+		{"rules": [{"action": "allow", "features": {"is_quick_play_realms": true}}], "value": ["--quickPlayRealms", "${quickPlayRealms}"]}
+	],
+	"jvm": ["-Djava.library.path=${natives_directory}", "-cp", "${classpath}"]
+}`
+
+func TestBuildLaunchCommandFeatures(t *testing.T) {
+	meta := &VersionMeta{ID: "1.21", MainClass: "net.minecraft.client.main.Main"}
+	err := json.Unmarshal([]byte(testFeatureArguments), &meta.Arguments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseDir := t.TempDir()
+	natives := filepath.Join(baseDir, "natives")
+	classpath := filepath.Join(baseDir, "versions", "1.21", "1.21.jar")
+	jvm := []string{"-Djava.library.path=" + natives, "-cp", classpath, meta.MainClass}
+	account := Account{Username: "Steve"}
+
+	tests := []struct {
+		name string
+		opts LaunchOptions
+		want []string
+	}{
+		{"plain", LaunchOptions{Account: account},
+			[]string{"--username", "Steve", "--version", "1.21"}},
+		{"resolution", LaunchOptions{Account: account, Width: 854, Height: 480},
+			[]string{"--username", "Steve", "--version", "1.21", "--width", "854", "--height", "480"}},
+		{"width only", LaunchOptions{Account: account, Width: 854},
+			[]string{"--username", "Steve", "--version", "1.21"}},
+		{"demo", LaunchOptions{Account: account, Demo: true},
+			[]string{"--username", "Steve", "--version", "1.21", "--demo"}},
+		{"singleplayer", LaunchOptions{Account: account, QuickPlayPath: "quickplay.json", QuickPlaySingleplayer: "New World"},
+			[]string{"--username", "Steve", "--version", "1.21", "--quickPlayPath", "quickplay.json", "--quickPlaySingleplayer", "New World"}},
+		{"multiplayer", LaunchOptions{Account: account, QuickPlayPath: "quickplay.json", QuickPlayMultiplayer: "mc.example.net"},
+			[]string{"--username", "Steve", "--version", "1.21", "--quickPlayPath", "quickplay.json", "--quickPlayMultiplayer", "mc.example.net"}},
+		{"xuid and fullscreen", LaunchOptions{Account: Account{Username: "Steve", XUID: "2535"}, Fullscreen: true, GameArgs: []string{"--extra"}},
+			[]string{"--username", "Steve", "--version", "1.21", "--xuid", "2535", "--fullscreen", "--extra"}},
+	}
+
+	env := Environment{OS: "linux", Arch: "x86_64"}
+	for _, test := range tests {
+		args, err := buildLaunchCommand(meta, baseDir, natives, test.opts.launchVars(meta, baseDir), test.opts, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append(append([]string(nil), jvm...), test.want...)
+		if fmt.Sprint(args) != fmt.Sprint(want) {
+			t.Errorf("%s: argv = %q, want %q", test.name, args, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package piston
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Account is the player and session the game is launched with.
+type Account struct {
+	Username    string
+	UUID        string
+	AccessToken string
+	// UserType is "msa" for Microsoft accounts and "legacy" for offline play.
+	UserType string
+	ClientID string
+	XUID     string
+}
+
+// LaunchOptions describes how a version is launched. Only Account.Username
+// is required.
+type LaunchOptions struct {
+	Account Account
+
+	// MinMemory and MaxMemory set the JVM heap in megabytes. Zero leaves the
+	// JVM default.
+	MinMemory uint32
+	MaxMemory uint32
+
+	// JVMArgs are added after the version's JVM arguments and GameArgs after
+	// its game arguments.
+	JVMArgs  []string
+	GameArgs []string
+
+	// Env is added to the launcher's own environment.
+	Env map[string]string
+
+	// GameDir holds saves, options and resource packs. It defaults to
+	// BasePath.
+	GameDir string
+	// WorkingDir is the process working directory. It defaults to GameDir.
+	WorkingDir string
+
+	// Width and Height set the initial window size, enabling the
+	// has_custom_resolution feature.
+	Width      int
+	Height     int
+	Fullscreen bool
+
+	// Demo launches the game in demo mode (is_demo_user).
+	Demo bool
+
+	// QuickPlayPath is where the game logs quick play sessions. Setting it
+	// enables has_quick_plays_support. At most one of QuickPlaySingleplayer (a
+	// world name), QuickPlayMultiplayer (a server address) and QuickPlayRealms
+	// (a realm ID) joins that world directly once the game has started. Quick
+	// play needs 1.20 or later.
+	QuickPlayPath         string
+	QuickPlaySingleplayer string
+	QuickPlayMultiplayer  string
+	QuickPlayRealms       string
+
+	// JavaPath overrides the java executable otherwise picked for the
+	// version.
+	JavaPath string
+
+	// Stdout and Stderr receive the game's output. When nil, the output is
+	// buffered on GameProcess.Stdout and GameProcess.Stderr instead.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// VersionType is shown in the game's F3 screen. It defaults to
+	// "piston.go".
+	VersionType string
+}
+
+func (opts LaunchOptions) gameDir(baseDir string) string {
+	if opts.GameDir != "" {
+		return opts.GameDir
+	}
+	return baseDir
+}
+
+func (opts LaunchOptions) workingDir(baseDir string) string {
+	if opts.WorkingDir != "" {
+		return opts.WorkingDir
+	}
+	return opts.gameDir(baseDir)
+}
+
+// launchVars returns the values substituted into the version's arguments.
+func (opts LaunchOptions) launchVars(meta *VersionMeta, baseDir string) map[string]string {
+	versionType := opts.VersionType
+	if versionType == "" {
+		versionType = "piston.go"
+	}
+
+	vars := map[string]string{
+		"auth_player_name":  opts.Account.Username,
+		"version_name":      meta.ID,
+		"game_directory":    opts.gameDir(baseDir),
+		"assets_root":       filepath.Join(baseDir, "assets"),
+		"game_assets":       filepath.Join(baseDir, "assets"),
+		"assets_index_name": meta.AssetIndex.ID,
+		"auth_access_token": opts.Account.AccessToken,
+		"auth_session":      opts.Account.AccessToken,
+		"auth_uuid":         opts.Account.UUID,
+		"user_type":         opts.Account.UserType,
+		"clientid":          opts.Account.ClientID,
+		"version_type":      versionType,
+		"user_properties":   "{}",
+		"launcher_name":     DefaultUserAgent,
+	}
+
+	if opts.Account.XUID != "" {
+		vars["auth_xuid"] = opts.Account.XUID
+	}
+	if opts.Width > 0 && opts.Height > 0 {
+		vars["resolution_width"] = strconv.Itoa(opts.Width)
+		vars["resolution_height"] = strconv.Itoa(opts.Height)
+	}
+	for key, value := range map[string]string{
+		"quickPlayPath":         opts.QuickPlayPath,
+		"quickPlaySingleplayer": opts.QuickPlaySingleplayer,
+		"quickPlayMultiplayer":  opts.QuickPlayMultiplayer,
+		"quickPlayRealms":       opts.QuickPlayRealms,
+	} {
+		if value != "" {
+			vars[key] = value
+		}
+	}
+
+	return vars
+}
+
+// features returns the rule features enabled by opts.
+func (opts LaunchOptions) features() map[string]bool {
+	return map[string]bool{
+		"is_demo_user":               opts.Demo,
+		"has_custom_resolution":      opts.Width > 0 && opts.Height > 0,
+		"has_quick_plays_support":    opts.QuickPlayPath != "",
+		"is_quick_play_singleplayer": opts.QuickPlaySingleplayer != "",
+		"is_quick_play_multiplayer":  opts.QuickPlayMultiplayer != "",
+		"is_quick_play_realms":       opts.QuickPlayRealms != "",
+	}
+}
+
+// legacyWindowArgs returns the game arguments for the requested window for
+// versions whose minecraftArguments have no resolution placeholders.
+func (opts LaunchOptions) legacyWindowArgs() []string {
+	var args []string
+	if opts.Width > 0 && opts.Height > 0 {
+		args = append(args, "--width", strconv.Itoa(opts.Width), "--height", strconv.Itoa(opts.Height))
+	}
+	if opts.Demo {
+		args = append(args, "--demo")
+	}
+	if opts.Fullscreen {
+		args = append(args, "--fullscreen")
+	}
+	return args
+}
+
+func (opts LaunchOptions) environ() []string {
+	if len(opts.Env) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	for key, value := range opts.Env {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
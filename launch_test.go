@@ -0,0 +1,136 @@
+package piston
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func installTestVersion(t *testing.T, baseDir string, id string, meta string) {
+	t.Helper()
+	path := filepath.Join(baseDir, "versions", id, id+".json")
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(meta), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGameCommandLegacyOptions(t *testing.T) {
+	launcher := PistonLauncher{
+		BasePath:    t.TempDir(),
+		Environment: &Environment{OS: "linux", Arch: "x86_64"},
+		Logger:      log.New(io.Discard, "", 0),
+	}
+	installTestVersion(t, launcher.BasePath, "1.12.2", `{
+		"id": "1.12.2",
+		"mainClass": "net.minecraft.client.main.Main",
+		"minecraftArguments": "--username ${auth_player_name} --gameDir ${game_directory}"
+	}`)
+
+	opts := LaunchOptions{
+		Account:    Account{Username: "Steve"},
+		MinMemory:  512,
+		MaxMemory:  2048,
+		Width:      854,
+		Height:     480,
+		Demo:       true,
+		Fullscreen: true,
+		JVMArgs:    []string{"-Dextra=1"},
+		GameArgs:   []string{"--extra"},
+		Env:        map[string]string{"PISTON_TEST": "1", "PATH": "/opt/bin"},
+		JavaPath:   filepath.Join(launcher.BasePath, "java"),
+	}
+	cmd, natives, err := launcher.gameCommand(context.Background(), "1.12.2", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(natives)
+
+	classpath := filepath.Join(launcher.BasePath, "versions", "1.12.2", "1.12.2.jar")
+	want := []string{
+		opts.JavaPath,
+		"-Xms512m", "-Xmx2048m", "-Djava.library.path=" + natives, "-cp", classpath, "-Dextra=1",
+		"net.minecraft.client.main.Main",
+		"--username", "Steve", "--gameDir", launcher.BasePath,
+		"--width", "854", "--height", "480", "--demo", "--fullscreen", "--extra",
+	}
+	if fmt.Sprint(cmd.Args) != fmt.Sprint(want) {
+		t.Errorf("argv = %q\nwant %q", cmd.Args, want)
+	}
+	if cmd.Dir != launcher.BasePath {
+		t.Errorf("Dir = %s, want BasePath", cmd.Dir)
+	}
+
+	// Env is added to the launcher's environment and wins over it, as the
+	// last value of a key is the one used.
+	if !slices.Contains(cmd.Env, "PISTON_TEST=1") || len(cmd.Env) < len(os.Environ()) {
+		t.Errorf("Env = %q, want the launcher's environment plus PISTON_TEST", cmd.Env)
+	}
+	for i := len(cmd.Env) - 1; i >= 0; i-- {
+		if key, value, _ := strings.Cut(cmd.Env[i], "="); key == "PATH" {
+			if value != "/opt/bin" {
+				t.Errorf("PATH = %s, want /opt/bin", value)
+			}
+			break
+		}
+	}
+}
+
+func TestGameCommandDirectories(t *testing.T) {
+	launcher := PistonLauncher{
+		BasePath:    t.TempDir(),
+		Environment: &Environment{OS: "linux", Arch: "x86_64"},
+		Logger:      log.New(io.Discard, "", 0),
+	}
+	installTestVersion(t, launcher.BasePath, "1.21", `{
+		"id": "1.21",
+		"mainClass": "net.minecraft.client.main.Main",
+		"arguments": {"game": ["--gameDir", "${game_directory}"], "jvm": []}
+	}`)
+
+	gameDir := filepath.Join(launcher.BasePath, "instances", "modded")
+	workingDir := filepath.Join(launcher.BasePath, "work")
+	tests := []struct {
+		name       string
+		opts       LaunchOptions
+		gameDir    string
+		workingDir string
+	}{
+		{"defaults", LaunchOptions{}, launcher.BasePath, launcher.BasePath},
+		{"game directory", LaunchOptions{GameDir: gameDir}, gameDir, gameDir},
+		{"working directory", LaunchOptions{GameDir: gameDir, WorkingDir: workingDir}, gameDir, workingDir},
+	}
+
+	for _, test := range tests {
+		test.opts.Account = Account{Username: "Steve"}
+		test.opts.JavaPath = filepath.Join(launcher.BasePath, "java")
+		cmd, natives, err := launcher.gameCommand(context.Background(), "1.21", test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(natives)
+
+		if got := cmd.Args[len(cmd.Args)-1]; got != test.gameDir {
+			t.Errorf("%s: --gameDir %s, want %s", test.name, got, test.gameDir)
+		}
+		if cmd.Dir != test.workingDir {
+			t.Errorf("%s: Dir = %s, want %s", test.name, cmd.Dir, test.workingDir)
+		}
+		if _, err := os.Stat(test.gameDir); err != nil {
+			t.Errorf("%s: the game directory was not created: %v", test.name, err)
+		}
+		// Without Env the game inherits the launcher's environment.
+		if cmd.Env != nil {
+			t.Errorf("%s: Env = %q, want nil", test.name, cmd.Env)
+		}
+	}
+}
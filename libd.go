@@ -1,20 +1,28 @@
 package piston
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func libraryPathFromName(name string) string {
+func libraryPathFromName(name string) (string, error) {
 	parts := strings.Split(name, ":")
 	if len(parts) < 3 {
-		log.Fatalf("Invalid library name: %s", name)
+		return "", fmt.Errorf("%w: %s", ErrInvalidLibraryName, name)
+	}
+
+	// The last part may name the extension, as in group:artifact:version@zip.
+	ext := "jar"
+	last := len(parts) - 1
+	if base, e, ok := strings.Cut(parts[last], "@"); ok {
+		parts[last], ext = base, e
 	}
 
 	group := strings.ReplaceAll(parts[0], ".", "/")
@@ -25,10 +33,10 @@ func libraryPathFromName(name string) string {
 	if len(parts) == 4 {
 		jarName += "-" + parts[3]
 	}
-	jarName += ".jar"
+	jarName += "." + ext
 
 	// Użyj path.Join, nie filepath.Join — path jest dla URL-i
-	return strings.ReplaceAll(filepath.Join(group, artifact, version, jarName), "\\", "/")
+	return strings.ReplaceAll(filepath.Join(group, artifact, version, jarName), "\\", "/"), nil
 }
 
 func fileExists(path string) bool {
@@ -36,56 +44,82 @@ func fileExists(path string) bool {
 	return !os.IsNotExist(err)
 }
 
-func sha1Matches(path string, expected string) bool {
-	data, err := os.ReadFile(path)
+// fileSum returns the hex-encoded hash of the file at path.
+func fileSum(path string, newHash func() hash.Hash) (string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return false
-	}
-	actual := sha1.Sum(data)
-	return hex.EncodeToString(actual[:]) == expected
-}
-
-func downloadLibrary(lib Library, baseDir string) {
-	if !isAllowed(lib.Rules) {
-		return
+		return "", err
 	}
+	defer file.Close()
 
-	artifact := lib.Downloads.Artifact
-	if artifact == nil {
-		log.Printf("Skipping library %s - no artifact", lib.Name)
-		return
+	h := newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	path := libraryPathFromName(lib.Name)
-	dest := filepath.Join(baseDir, "libraries", path)
-
-	if fileExists(dest) && sha1Matches(dest, artifact.SHA1) {
-		log.Printf("Library %s already downloaded.", lib.Name)
-		return
+// validSHA1 reports whether s is a hex-encoded SHA-1.
+func validSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
 	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
 
-	err := os.MkdirAll(filepath.Dir(dest), 0755)
-	if err != nil {
-		log.Fatalf("Failed to create directory: %s", err)
-	}
+func sha1Matches(path string, expected string) bool {
+	sum, err := fileSum(path, sha1.New)
+	return err == nil && strings.EqualFold(sum, expected)
+}
 
-	log.Printf("Downloading %s", artifact.URL)
-	resp, err := http.Get(artifact.URL)
-	if err != nil {
-		log.Fatalf("Failed to download: %s", err)
+// fileValid reports whether path holds the file described by sha1 and size.
+// Empty or zero values are not checked, so a file without a published hash
+// is valid as long as it exists with the right size.
+func fileValid(path string, sha1 string, size int64) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
 	}
-	defer resp.Body.Close()
-
-	out, err := os.Create(dest)
-	if err != nil {
-		log.Fatalf("Failed to create file: %s", err)
+	if size > 0 && info.Size() != size {
+		return false
 	}
-	defer out.Close()
+	return sha1 == "" || sha1Matches(path, sha1)
+}
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to write file: %s", err)
+func downloadLibraries(ctx context.Context, sched *scheduler, meta *VersionMeta, baseDir string) error {
+	var tasks []downloadTask
+	for _, lib := range meta.Libraries {
+		// Natives artifacts are fetched and extracted by downloadNatives.
+		if !sched.env.Allows(lib.Rules) || nativesClassifierOf(lib.Name) != "" {
+			continue
+		}
+
+		artifact := lib.Downloads.Artifact
+		if artifact == nil {
+			sched.logger.Printf("Skipping library %s - no artifact", lib.Name)
+			continue
+		}
+		// Installers ship some libraries inside their jar instead.
+		if artifact.URL == "" {
+			continue
+		}
+
+		path, err := libraryPathFromName(lib.Name)
+		if err != nil {
+			return err
+		}
+
+		tasks = append(tasks, downloadTask{
+			URL:  artifact.URL,
+			Dest: filepath.Join(baseDir, "libraries", path),
+			SHA1: artifact.SHA1,
+			Size: int64(artifact.Size),
+		})
 	}
 
-	log.Printf("Library %s downloaded to %s", lib.Name, dest)
-}
\ No newline at end of file
+	sched.logger.Printf("Downloading %d libraries", len(tasks))
+	startPhase(sched.progress, PhaseLibraries, len(tasks), tasksSize(tasks))
+	err := sched.run(ctx, PhaseLibraries, tasks)
+	return finishPhase(sched.progress, PhaseLibraries, err)
+}
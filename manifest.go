@@ -1,201 +1,233 @@
 package piston
 
 import (
-	"encoding/json"
-	"io"
-	"log"
-	"net/http"
-	"runtime"
+	"context"
+	"fmt"
 	"strings"
 )
 
-func fetchManifest() *VersionManifest {
-	resp, err := http.Get("https://piston-meta.mojang.com/mc/game/version_manifest_v2.json")
-	if err != nil {
-		log.Fatalf("Failed to fetch manifest: %s", err)
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to read response: %s", err)
+// fetchManifest returns the version list, from the cache while it is fresh.
+// With refresh set the cache is bypassed unless the launcher is offline.
+func fetchManifest(ctx context.Context, sched *scheduler, refresh bool) (*VersionManifest, error) {
+	ttl := sched.manifestTTL
+	if refresh {
+		ttl = 0
 	}
 
 	var manifest VersionManifest
-	err = json.Unmarshal(data, &manifest)
+	err := sched.fetchCachedJSON(ctx, "https://"+MojangMetaHost+"/mc/game/version_manifest_v2.json", ttl, &manifest)
 	if err != nil {
-		log.Fatalf("Failed to parse JSON: %s", err)
+		return nil, err
 	}
 
-	return &manifest
+	return &manifest, nil
 }
 
-func fetchVersionManifest(url string) *VersionMeta {
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Fatalf("Failed to fetch version manifest: %s", err)
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to read version manifest: %s", err)
+// fetchVersionManifest returns the version JSON at url, checked against sha1
+// or, when sha1 is empty, against the SHA-1 a Mojang package URL embeds.
+// Version JSONs with neither are cached like the version list.
+func fetchVersionManifest(ctx context.Context, sched *scheduler, url string, sha1 string) (*VersionMeta, error) {
+	if sha1 == "" {
+		sha1 = packageSHA1(url)
 	}
 
 	var meta VersionMeta
-	err = json.Unmarshal(data, &meta)
+	var err error
+	if sha1 != "" {
+		err = sched.fetchVerifiedJSON(ctx, url, sha1, &meta)
+	} else {
+		err = sched.fetchCachedJSON(ctx, url, sched.manifestTTL, &meta)
+	}
 	if err != nil {
-		log.Fatalf("Failed to parse version JSON: %s", err)
+		return nil, err
 	}
 
-	return &meta
+	return &meta, nil
 }
 
-func fetchFabricManifest() *FabricManifest {
-	resp, err := http.Get("https://meta.fabricmc.net/v2/versions")
-	if err != nil {
-		log.Fatalf("Failed to fetch manifest: %s", err)
+// packageSHA1 returns the SHA-1 embedded in a Mojang package URL such as
+// https://piston-meta.mojang.com/v1/packages/<sha1>/1.21.json, or "".
+func packageSHA1(url string) string {
+	_, rest, ok := strings.Cut(url, "/packages/")
+	if !ok {
+		return ""
 	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to read response: %s", err)
+	sum, _, ok := strings.Cut(rest, "/")
+	if !ok || len(sum) != 40 || strings.Trim(strings.ToLower(sum), "0123456789abcdef") != "" {
+		return ""
 	}
+	return sum
+}
 
+func fetchFabricManifest(ctx context.Context, sched *scheduler) (*FabricManifest, error) {
 	var manifest FabricManifest
-	err = json.Unmarshal(data, &manifest)
+	err := sched.fetchCachedJSON(ctx, "https://"+FabricMetaHost+"/v2/versions", sched.manifestTTL, &manifest)
 	if err != nil {
-		log.Fatalf("Failed to parse JSON: %s", err)
+		return nil, err
 	}
 
-	return &manifest
+	return &manifest, nil
 }
 
-func fetchFabricLoaderManifest(version string) *[]FabricMeta {
-		resp, err := http.Get("https://meta.fabricmc.net/v2/versions/loader/"+version)
-	if err != nil {
-		log.Fatalf("Failed to fetch manifest: %s", err)
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to read response: %s", err)
-	}
-
+func fetchFabricLoaderManifest(ctx context.Context, sched *scheduler, version string) ([]FabricMeta, error) {
 	var manifest []FabricMeta
-	err = json.Unmarshal(data, &manifest)
+	err := sched.fetchCachedJSON(ctx, "https://"+FabricMetaHost+"/v2/versions/loader/"+version, sched.manifestTTL, &manifest)
 	if err != nil {
-		log.Fatalf("Failed to parse JSON: %s", err)
+		return nil, err
 	}
 
-	return &manifest
+	return manifest, nil
 }
 
-func fetchFabricLoaderMeta(version string, loader string) *FabricMeta {
-	var meta FabricMeta
+func fetchFabricLoaderMeta(ctx context.Context, sched *scheduler, version string, loader string) (*FabricMeta, error) {
+	manifest, err := fetchFabricLoaderManifest(ctx, sched, version)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, met := range *fetchFabricLoaderManifest(version) {
+	for _, met := range manifest {
 		if met.Loader.Version == loader {
-			meta = met
-			break
+			return &met, nil
 		}
 	}
-	
-	return &meta
+
+	return nil, fmt.Errorf("%w: %s with loader %s", ErrFabricLoaderNotFound, version, loader)
 }
 
-func (fabricMeta FabricMeta) patchVersionManifest(meta *VersionMeta) *VersionMeta {
+func (fabricMeta FabricMeta) patchVersionManifest(meta *VersionMeta) (*VersionMeta, error) {
 	meta.MainClass = fabricMeta.LauncherMeta.MainClass.Client
 	meta.ID = meta.ID + "-fabric"
 
 	return mergeLibraries(meta, fabricMeta)
 }
 
-func mergeLibraries(meta *VersionMeta, fabricMeta FabricMeta) *VersionMeta {
-    // Zrób mapę fabricowych bibliotek po group:artifact (bez wersji)
-    fabricLibGA := make(map[string]Library)
-    for _, lib := range fabricMeta.LauncherMeta.Libraries.Common {
-        ga := groupArtifact(lib.Name)
-        fabricLibGA[ga] = Library{
-            Name: lib.Name,
-            Downloads: LibraryDownloads{
-                Artifact: &DownloadInfo{
-                    URL:  lib.Name + libraryPathFromName(lib.Name),
-                    SHA1: lib.Sha1,
-                    Size: lib.Size,
-                },
-                Classifiers: map[string]*DownloadInfo{},
-            },
-        }
-    }
-    for _, lib := range fabricMeta.LauncherMeta.Libraries.Client {
-        ga := groupArtifact(lib.Name)
-        fabricLibGA[ga] = Library{
-            Name: lib.Name,
-            Downloads: LibraryDownloads{
-                Artifact: &DownloadInfo{
-                    URL:  lib.Name + libraryPathFromName(lib.Name),
-                    SHA1: lib.Sha1,
-                    Size: lib.Size,
-                },
-                Classifiers: map[string]*DownloadInfo{},
-            },
-        }
-    }
-    // Dodaj Loader
-    gaLoader := groupArtifact(fabricMeta.Loader.Maven)
-    fabricLibGA[gaLoader] = Library{
-        Name: fabricMeta.Loader.Maven,
-        Downloads: LibraryDownloads{
-            Artifact: &DownloadInfo{
-                URL: "https://maven.fabricmc.net/" + libraryPathFromName(fabricMeta.Loader.Maven),
-            },
-            Classifiers: map[string]*DownloadInfo{},
-        },
-    }
+func mergeLibraries(meta *VersionMeta, fabricMeta FabricMeta) (*VersionMeta, error) {
+	// Zrób mapę fabricowych bibliotek po group:artifact (bez wersji)
+	fabricLibGA := make(map[string]Library)
+	var fabricLibs []FabricLibrary
+	fabricLibs = append(fabricLibs, fabricMeta.LauncherMeta.Libraries.Common...)
+	fabricLibs = append(fabricLibs, fabricMeta.LauncherMeta.Libraries.Client...)
+	for _, lib := range fabricLibs {
+		path, err := libraryPathFromName(lib.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		fabricLibGA[groupArtifact(lib.Name)] = Library{
+			Name: lib.Name,
+			Downloads: LibraryDownloads{
+				Artifact: &DownloadInfo{
+					URL:  strings.TrimSuffix(lib.Url, "/") + "/" + path,
+					SHA1: lib.Sha1,
+					Size: lib.Size,
+				},
+				Classifiers: map[string]*DownloadInfo{},
+			},
+		}
+	}
 
-    // Wynikowa lista bibliotek
-    merged := []Library{}
+	// Dodaj Loader
+	loaderPath, err := libraryPathFromName(fabricMeta.Loader.Maven)
+	if err != nil {
+		return nil, err
+	}
+	fabricLibGA[groupArtifact(fabricMeta.Loader.Maven)] = Library{
+		Name: fabricMeta.Loader.Maven,
+		Downloads: LibraryDownloads{
+			Artifact: &DownloadInfo{
+				URL: "https://" + FabricMavenHost + "/" + loaderPath,
+			},
+			Classifiers: map[string]*DownloadInfo{},
+		},
+	}
 
-    // Dodaj tylko vanilla, które nie mają odpowiednika fabricowego
-    for _, lib := range meta.Libraries {
-        ga := groupArtifact(lib.Name)
-        if _, found := fabricLibGA[ga]; !found {
-            merged = append(merged, lib)
-        }
-    }
+	// Wynikowa lista bibliotek
+	merged := []Library{}
 
-    // Dodaj WSZYSTKIE fabricowe biblioteki
-    for _, lib := range fabricLibGA {
-        merged = append(merged, lib)
-    }
+	// Dodaj tylko vanilla, które nie mają odpowiednika fabricowego
+	for _, lib := range meta.Libraries {
+		ga := groupArtifact(lib.Name)
+		if _, found := fabricLibGA[ga]; !found {
+			merged = append(merged, lib)
+		}
+	}
+
+	// Dodaj WSZYSTKIE fabricowe biblioteki
+	for _, lib := range fabricLibGA {
+		merged = append(merged, lib)
+	}
 
-    meta.Libraries = merged
-    return meta
+	meta.Libraries = merged
+	return meta, nil
 }
 
-func isAllowed(rules []Rule) bool {
-	if len(rules) == 0 {
-		return true
+// findVersion returns the entry of id in the version list.
+func findVersion(ctx context.Context, sched *scheduler, id string) (VersionEntry, error) {
+	manifest, err := fetchManifest(ctx, sched, false)
+	if err != nil {
+		return VersionEntry{}, err
 	}
 
-	currentOS := runtime.GOOS
-	allowed := false
+	for _, entry := range manifest.Versions {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
 
-	for _, rule := range rules {
-		match := rule.OS.Name == "" || rule.OS.Name == currentOS
-		if match {
-			if rule.Action == "allow" {
-				allowed = true
-			} else if rule.Action == "disallow" {
-				return false
-			}
+	return VersionEntry{}, fmt.Errorf("%w: %s", ErrUnknownVersion, id)
+}
+
+// libraryKey identifies a library regardless of its version, keeping the
+// classifier so that e.g. natives are not mistaken for the main jar.
+func libraryKey(name string) string {
+	name, _, _ = strings.Cut(name, "@")
+	parts := strings.Split(name, ":")
+	if len(parts) >= 4 {
+		return parts[0] + ":" + parts[1] + ":" + parts[3]
+	}
+	return groupArtifact(name)
+}
+
+// mergeInheritedVersion flattens child, whose inheritsFrom names parent, into
+// a single launchable version. The child's libraries replace the parent's
+// versions of the same library and its arguments are added after the
+// parent's.
+func mergeInheritedVersion(parent *VersionMeta, child *VersionMeta) *VersionMeta {
+	merged := *parent
+	merged.ID = child.ID
+	merged.InheritsFrom = ""
+
+	if child.MainClass != "" {
+		merged.MainClass = child.MainClass
+	}
+	if child.OlderArguments != "" {
+		merged.OlderArguments = child.OlderArguments
+	}
+	if child.JavaVersion != nil {
+		merged.JavaVersion = child.JavaVersion
+	}
+	if child.AssetIndex.ID != "" {
+		merged.AssetIndex = child.AssetIndex
+	}
+
+	merged.Arguments = VersionArguments{
+		Game: append(append([]Argument{}, parent.Arguments.Game...), child.Arguments.Game...),
+		JVM:  append(append([]Argument{}, parent.Arguments.JVM...), child.Arguments.JVM...),
+	}
+
+	seen := map[string]bool{}
+	merged.Libraries = nil
+	for _, lib := range child.Libraries {
+		seen[libraryKey(lib.Name)] = true
+		merged.Libraries = append(merged.Libraries, lib)
+	}
+	for _, lib := range parent.Libraries {
+		if !seen[libraryKey(lib.Name)] {
+			merged.Libraries = append(merged.Libraries, lib)
 		}
 	}
 
-	return allowed
+	return &merged
 }
 
 func groupArtifact(name string) string {
@@ -0,0 +1,418 @@
+package piston
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const mojangRuntimeIndexURL = "https://" + MojangLauncherMetaHost + "/v1/products/java-runtime/2ec0cc96c44e5a76b9c8b7c39df7210883d12871/all.json"
+
+// runtimeMarker is written into a runtime directory once every file of it is
+// in place and records the installed release.
+const runtimeMarker = ".version"
+
+// Components used when a version JSON names a major version but no component.
+var defaultRuntimeComponents = map[uint16]string{
+	8:  "jre-legacy",
+	16: "java-runtime-alpha",
+	17: "java-runtime-gamma",
+	21: "java-runtime-delta",
+}
+
+// MojangRuntimeProvider installs the Java runtimes Mojang publishes for the
+// official launcher into BasePath/runtime/<component>. Runtimes are
+// downloaded file by file, so updating one only fetches what changed.
+//
+// Files are fetched as their LZMA variant where the manifest has one and
+// checked against the raw file's SHA-1 once unpacked.
+type MojangRuntimeProvider struct{}
+
+func runtimeComponent(java JavaVersion) string {
+	if java.Component != "" {
+		return java.Component
+	}
+	return defaultRuntimeComponents[java.MajorVersion]
+}
+
+func mojangRuntimePlatform() string {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64":
+		return "linux"
+	case "linux/386":
+		return "linux-i386"
+	case "darwin/amd64":
+		return "mac-os"
+	case "darwin/arm64":
+		return "mac-os-arm64"
+	case "windows/amd64":
+		return "windows-x64"
+	case "windows/386":
+		return "windows-x86"
+	case "windows/arm64":
+		return "windows-arm64"
+	default:
+		return runtime.GOOS + "-" + runtime.GOARCH
+	}
+}
+
+func (MojangRuntimeProvider) dir(launcher *PistonLauncher, component string) string {
+	return filepath.Join(launcher.BasePath, "runtime", component)
+}
+
+func (p MojangRuntimeProvider) Find(launcher *PistonLauncher, java JavaVersion) string {
+	component := runtimeComponent(java)
+	if component == "" {
+		return ""
+	}
+
+	dir := p.dir(launcher, component)
+	if !fileExists(filepath.Join(dir, runtimeMarker)) {
+		return ""
+	}
+	return findJavaExecutable(dir)
+}
+
+func (p MojangRuntimeProvider) Remove(launcher *PistonLauncher, java JavaVersion) error {
+	component := runtimeComponent(java)
+	if component == "" {
+		return nil
+	}
+	return os.RemoveAll(p.dir(launcher, component))
+}
+
+func (p MojangRuntimeProvider) Install(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error {
+	component := runtimeComponent(java)
+	if component == "" {
+		return fmt.Errorf("%w: no Mojang runtime for Java %d", ErrJavaNotFound, java.MajorVersion)
+	}
+
+	sched := launcher.scheduler()
+	entry, err := fetchRuntimeEntry(ctx, sched, component, sched.manifestTTL)
+	if err != nil {
+		return err
+	}
+
+	var manifest JavaRuntimeManifest
+	err = sched.fetchVerifiedJSON(ctx, entry.Manifest.URL, entry.Manifest.SHA1, &manifest)
+	if err != nil {
+		return err
+	}
+
+	return installMojangRuntime(ctx, sched, p.dir(launcher, component), entry, manifest)
+}
+
+func (p MojangRuntimeProvider) Installed(launcher *PistonLauncher) ([]JavaRuntime, error) {
+	entries, err := os.ReadDir(filepath.Join(launcher.BasePath, "runtime"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runtimes []JavaRuntime
+	for _, entry := range entries {
+		dir := p.dir(launcher, entry.Name())
+		release, err := os.ReadFile(filepath.Join(dir, runtimeMarker))
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		java := JavaVersion{Component: entry.Name(), MajorVersion: javaMajorFromSpec(string(release))}
+		for major, component := range defaultRuntimeComponents {
+			if component == entry.Name() {
+				java.MajorVersion = major
+			}
+		}
+		runtimes = append(runtimes, JavaRuntime{Java: java, Release: string(release), Path: findJavaExecutable(dir)})
+	}
+
+	return runtimes, nil
+}
+
+func (MojangRuntimeProvider) Latest(ctx context.Context, launcher *PistonLauncher, java JavaVersion) (string, error) {
+	component := runtimeComponent(java)
+	if component == "" {
+		return "", fmt.Errorf("%w: no Mojang runtime for Java %d", ErrJavaNotFound, java.MajorVersion)
+	}
+
+	entry, err := fetchRuntimeEntry(ctx, launcher.scheduler(), component, 0)
+	if err != nil {
+		return "", err
+	}
+	return entry.Version.Name, nil
+}
+
+// Upgrade brings the runtime up to the latest release. The new release is
+// staged next to the current one, reusing the files that did not change so
+// only the others are downloaded, and then swapped in. The current runtime
+// stays usable if the upgrade fails, including when a running game keeps it
+// from being replaced.
+func (p MojangRuntimeProvider) Upgrade(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error {
+	component := runtimeComponent(java)
+	if component == "" {
+		return fmt.Errorf("%w: no Mojang runtime for Java %d", ErrJavaNotFound, java.MajorVersion)
+	}
+
+	sched := launcher.scheduler()
+	entry, err := fetchRuntimeEntry(ctx, sched, component, 0)
+	if err != nil {
+		return err
+	}
+
+	dir := p.dir(launcher, component)
+	current, err := os.ReadFile(filepath.Join(dir, runtimeMarker))
+	if err == nil && string(current) == entry.Version.Name {
+		return nil
+	}
+
+	var manifest JavaRuntimeManifest
+	err = sched.fetchVerifiedJSON(ctx, entry.Manifest.URL, entry.Manifest.SHA1, &manifest)
+	if err != nil {
+		return err
+	}
+
+	staging := dir + ".new"
+	err = os.RemoveAll(staging)
+	if err == nil {
+		err = stageRuntime(dir, staging, manifest)
+	}
+	if err == nil {
+		err = installMojangRuntime(ctx, sched, staging, entry, manifest)
+	}
+	if err == nil {
+		err = replaceDir(staging, dir)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+	}
+	return err
+}
+
+// stageRuntime seeds staging with the files of the runtime in dir that
+// manifest still lists unchanged, hard-linking them where the file system
+// allows it and copying them otherwise.
+func stageRuntime(dir string, staging string, manifest JavaRuntimeManifest) error {
+	for name, file := range manifest.Files {
+		raw, ok := file.Downloads["raw"]
+		if file.Type != "file" || !ok {
+			continue
+		}
+
+		src, err := safeJoin(dir, name)
+		if err != nil {
+			return err
+		}
+		dest, err := safeJoin(staging, name)
+		if err != nil {
+			return err
+		}
+		if !fileValid(src, raw.SHA1, int64(raw.Size)) {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(dest), 0755)
+		if err != nil {
+			return err
+		}
+		if os.Link(src, dest) == nil {
+			continue
+		}
+		err = copyFile(src, dest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeExtracted(context.Background(), dest, in, 0644)
+}
+
+func fetchRuntimeEntry(ctx context.Context, sched *scheduler, component string, ttl time.Duration) (JavaRuntimeEntry, error) {
+	var index JavaRuntimeIndex
+	err := sched.fetchCachedJSON(ctx, mojangRuntimeIndexURL, ttl, &index)
+	if err != nil {
+		return JavaRuntimeEntry{}, err
+	}
+
+	entries := index[mojangRuntimePlatform()][component]
+	if len(entries) == 0 {
+		return JavaRuntimeEntry{}, fmt.Errorf("%w: %s is not published for %s", ErrJavaNotFound, component, mojangRuntimePlatform())
+	}
+	return entries[0], nil
+}
+
+func installMojangRuntime(ctx context.Context, sched *scheduler, dir string, entry JavaRuntimeEntry, manifest JavaRuntimeManifest) error {
+	// Until the marker is rewritten the runtime counts as not installed, so
+	// an interrupted update is retried rather than launched.
+	marker := filepath.Join(dir, runtimeMarker)
+	os.Remove(marker)
+
+	var tasks []downloadTask
+	var executables []string
+	packed := map[string]Download{}
+	links := map[string]string{}
+	keep := map[string]bool{marker: true}
+
+	for name, file := range manifest.Files {
+		path, err := safeJoin(dir, name)
+		if err != nil {
+			return err
+		}
+		keep[path] = true
+
+		switch file.Type {
+		case "directory":
+			err = os.MkdirAll(path, 0755)
+			if err != nil {
+				return err
+			}
+		case "file":
+			raw, ok := file.Downloads["raw"]
+			if !ok {
+				return fmt.Errorf("no raw download for %s", name)
+			}
+			task := downloadTask{URL: raw.URL, Dest: path, SHA1: raw.SHA1, Size: int64(raw.Size)}
+			// The LZMA variant is much smaller, but only worth fetching
+			// when the file is not in place already.
+			if lzma, ok := file.Downloads["lzma"]; ok && !task.valid() {
+				task = downloadTask{URL: lzma.URL, Dest: path + ".lzma", SHA1: lzma.SHA1, Size: int64(lzma.Size)}
+				packed[path] = raw
+			}
+			tasks = append(tasks, task)
+			if file.Executable {
+				executables = append(executables, path)
+			}
+		case "link":
+			if filepath.IsAbs(file.Target) || !within(dir, filepath.Join(filepath.Dir(path), file.Target)) {
+				return fmt.Errorf("illegal link target: %s -> %s", name, file.Target)
+			}
+			links[path] = file.Target
+		}
+	}
+
+	startPhase(sched.progress, PhaseJDK, len(tasks), tasksSize(tasks))
+	err := sched.run(ctx, PhaseJDK, tasks)
+	for path, raw := range packed {
+		if err != nil {
+			break
+		}
+		err = unpackRuntimeFile(ctx, path+".lzma", path, raw)
+	}
+	if err == nil {
+		err = finishRuntime(dir, executables, links, keep)
+	}
+	if err == nil {
+		err = os.WriteFile(marker, []byte(entry.Version.Name), 0644)
+	}
+	return finishPhase(sched.progress, PhaseJDK, err)
+}
+
+// unpackRuntimeFile decompresses the LZMA file at packed into dest, checking
+// the result against raw, the download it stands for. packed is removed
+// either way, so a bad file is downloaded again next time.
+func unpackRuntimeFile(ctx context.Context, packed string, dest string, raw Download) error {
+	defer os.Remove(packed)
+
+	in, err := os.Open(packed)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := lzma.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", packed, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.part")
+	if err != nil {
+		return err
+	}
+
+	sums := newChecksums()
+	written, err := io.Copy(io.MultiWriter(tmp, sums), contextReader{ctx, r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to unpack %s: %w", packed, err)
+	}
+	if err == nil {
+		err = verifyDownload(downloadTask{Dest: dest, SHA1: raw.SHA1, Size: int64(raw.Size)}, written, sums)
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// finishRuntime restores executable bits and links and removes files left
+// over from a previous release of the runtime.
+func finishRuntime(dir string, executables []string, links map[string]string, keep map[string]bool) error {
+	for _, path := range executables {
+		err := os.Chmod(path, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	for path, target := range links {
+		if current, err := os.Readlink(path); err == nil && current == target {
+			continue
+		}
+		os.Remove(path)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.Symlink(target, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var stale []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && !keep[path] {
+			stale = append(stale, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range stale {
+		os.RemoveAll(path)
+	}
+	return nil
+}
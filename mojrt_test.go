@@ -0,0 +1,185 @@
+package piston
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// addRuntime publishes release of component with files mapping names onto
+// their content.
+func (m *testMirror) addRuntime(t *testing.T, component string, release string, files map[string]string) {
+	t.Helper()
+	manifest := JavaRuntimeManifest{Files: map[string]JavaRuntimeFile{"bin": {Type: "directory"}, "lib": {Type: "directory"}}}
+	for name, content := range files {
+		fileURL := m.add(MojangDataHost, "/v1/objects/"+sha1Hex([]byte(content))+"/"+filepath.Base(name), []byte(content))
+		manifest.Files[name] = JavaRuntimeFile{
+			Type:       "file",
+			Executable: name == "bin/java",
+			Downloads:  map[string]Download{"raw": {URL: fileURL, SHA1: sha1Hex([]byte(content)), Size: len(content)}},
+		}
+	}
+	m.publishRuntime(component, release, manifest)
+}
+
+// publishRuntime serves manifest as release of component.
+func (m *testMirror) publishRuntime(component string, release string, manifest JavaRuntimeManifest) {
+	data, _ := json.Marshal(manifest)
+	manifestURL := m.add(MojangLauncherMetaHost, "/v1/packages/"+sha1Hex(data)+"/manifest.json", data)
+
+	var entry JavaRuntimeEntry
+	entry.Manifest = Download{URL: manifestURL, SHA1: sha1Hex(data), Size: len(data)}
+	entry.Version.Name = release
+	index, _ := json.Marshal(JavaRuntimeIndex{mojangRuntimePlatform(): {component: {entry}}})
+
+	u, _ := url.Parse(mojangRuntimeIndexURL)
+	m.add(MojangLauncherMetaHost, u.Path, index)
+}
+
+func TestMojangRuntimeUpgrade(t *testing.T) {
+	m := newTestMirror(t)
+	launcher := m.launcher(t)
+	provider := MojangRuntimeProvider{}
+	java := JavaVersion{MajorVersion: 17}
+	ctx := context.Background()
+
+	m.addRuntime(t, "java-runtime-gamma", "17.0.8", map[string]string{"bin/java": "java 17.0.8", "lib/modules": "modules"})
+	err := provider.Install(ctx, &launcher, java)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := provider.dir(&launcher, "java-runtime-gamma")
+	modules, err := os.Stat(filepath.Join(dir, "lib", "modules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed upgrade leaves the current runtime in place.
+	m.addRuntime(t, "java-runtime-gamma", "17.0.9", map[string]string{"bin/java": "java 17.0.9", "lib/modules": "modules"})
+	m.remove("/v1/objects/" + sha1Hex([]byte("java 17.0.9")) + "/java")
+	err = provider.Upgrade(ctx, &launcher, java)
+	if err == nil {
+		t.Fatal("upgrade succeeded without its files")
+	}
+	if provider.Find(&launcher, java) == "" {
+		t.Fatal("the runtime is gone after a failed upgrade")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "bin", "java")); string(data) != "java 17.0.8" {
+		t.Errorf("bin/java = %q after a failed upgrade", data)
+	}
+	if _, err := os.Stat(dir + ".new"); !os.IsNotExist(err) {
+		t.Error("the staging directory was left behind")
+	}
+
+	m.addRuntime(t, "java-runtime-gamma", "17.0.9", map[string]string{"bin/java": "java 17.0.9", "lib/modules": "modules"})
+	err = provider.Upgrade(ctx, &launcher, java)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtimes, err := provider.Installed(&launcher)
+	if err != nil || len(runtimes) != 1 || runtimes[0].Release != "17.0.9" {
+		t.Fatalf("Installed = %+v, %v", runtimes, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "bin", "java")); string(data) != "java 17.0.9" {
+		t.Errorf("bin/java = %q after the upgrade", data)
+	}
+	if n := m.served("/v1/objects/" + sha1Hex([]byte("modules")) + "/modules"); n != 1 {
+		t.Errorf("the unchanged file was downloaded %d times", n)
+	}
+	upgraded, err := os.Stat(filepath.Join(dir, "lib", "modules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(modules, upgraded) {
+		t.Error("the unchanged file was not hard-linked into the new release")
+	}
+}
+
+func lzmaBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := lzma.NewWriter(&buf)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMojangRuntimeInstallsLZMA(t *testing.T) {
+	m := newTestMirror(t)
+	launcher := m.launcher(t)
+	provider := MojangRuntimeProvider{}
+	java := JavaVersion{MajorVersion: 21}
+	ctx := context.Background()
+
+	// Only the LZMA variants are served, so the raw downloads cannot be
+	// used in their place.
+	runtimeFile := func(content string, packedContent string, executable bool) JavaRuntimeFile {
+		packed := lzmaBytes(t, []byte(packedContent))
+		packedURL := m.add(MojangDataHost, "/v1/objects/"+sha1Hex(packed)+"/"+sha1Hex([]byte(content)), packed)
+		return JavaRuntimeFile{Type: "file", Executable: executable, Downloads: map[string]Download{
+			"raw":  {URL: "https://" + MojangDataHost + "/raw/" + sha1Hex([]byte(content)), SHA1: sha1Hex([]byte(content)), Size: len(content)},
+			"lzma": {URL: packedURL, SHA1: sha1Hex(packed), Size: len(packed)},
+		}}
+	}
+
+	manifest := JavaRuntimeManifest{Files: map[string]JavaRuntimeFile{"bin": {Type: "directory"}, "bin/java": runtimeFile("java 21", "java 21", true)}}
+	m.publishRuntime("java-runtime-delta", "21.0.3", manifest)
+	err := provider.Install(ctx, &launcher, java)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := provider.dir(&launcher, "java-runtime-delta")
+	if data, _ := os.ReadFile(filepath.Join(dir, "bin", "java")); string(data) != "java 21" {
+		t.Errorf("bin/java = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin", "java.lzma")); !os.IsNotExist(err) {
+		t.Error("the LZMA file was left behind")
+	}
+
+	// An LZMA file that unpacks to something else than the raw file is
+	// rejected.
+	manifest.Files["lib/modules"] = runtimeFile("modules", "MODULES", false)
+	m.publishRuntime("java-runtime-delta", "21.0.4", manifest)
+	err = provider.Upgrade(ctx, &launcher, java)
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("err = %v, want a *ChecksumError", err)
+	}
+}
+
+func TestMojangRuntimeVerifiesManifest(t *testing.T) {
+	m := newTestMirror(t)
+	launcher := m.launcher(t)
+	provider := MojangRuntimeProvider{}
+	java := JavaVersion{MajorVersion: 17}
+
+	m.addRuntime(t, "java-runtime-gamma", "17.0.8", map[string]string{"bin/java": "java 17.0.8"})
+	u, _ := url.Parse(mojangRuntimeIndexURL)
+	var index JavaRuntimeIndex
+	if err := json.Unmarshal(m.file(u.Path), &index); err != nil {
+		t.Fatal(err)
+	}
+	manifestURL, _ := url.Parse(index[mojangRuntimePlatform()]["java-runtime-gamma"][0].Manifest.URL)
+	m.add(MojangLauncherMetaHost, manifestURL.Path, []byte(`{"files": {}}`))
+
+	err := provider.Install(context.Background(), &launcher, java)
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("err = %v, want a *ChecksumError", err)
+	}
+}
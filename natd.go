@@ -2,72 +2,192 @@ package piston
 
 import (
 	"archive/zip"
-	"io"
-	"log"
-	"net/http"
+	"context"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func extractNatives(jarPath string, outputDir string) {
+// nativeJar is a jar of natives to extract, with the entry prefixes its
+// library excludes from extraction.
+type nativeJar struct {
+	task    downloadTask
+	exclude []string
+}
+
+func (lib Library) excludes() []string {
+	if lib.Extract == nil {
+		return nil
+	}
+	return lib.Extract.Exclude
+}
+
+// nativesClassifierOf returns the classifier of a 1.19+ natives artifact such
+// as org.lwjgl:lwjgl:3.3.3:natives-linux, or "" for any other library.
+func nativesClassifierOf(name string) string {
+	parts := strings.Split(name, ":")
+	if len(parts) < 4 || !strings.HasPrefix(parts[3], "natives-") {
+		return ""
+	}
+	return parts[3]
+}
+
+// nativesArch returns the architecture a natives classifier targets. Plain
+// classifiers such as natives-linux or natives-macos are for x86_64.
+func nativesArch(classifier string) string {
+	switch {
+	case strings.HasSuffix(classifier, "-arm64"), strings.HasSuffix(classifier, "-aarch64"):
+		return "arm64"
+	case strings.HasSuffix(classifier, "-x86"):
+		return "x86"
+	default:
+		return "x86_64"
+	}
+}
+
+// nativeArtifacts returns the natives artifacts to extract in env. Their OS
+// rules admit every architecture variant of a library, so for each library
+// only the variant for env's architecture is kept, falling back to the plain
+// one when the library has no such variant.
+func nativeArtifacts(libs []Library, env Environment) []Library {
+	variants := map[string][]Library{}
+	var order []string
+	for _, lib := range libs {
+		classifier := nativesClassifierOf(lib.Name)
+		if classifier == "" || !env.Allows(lib.Rules) || lib.Downloads.Artifact == nil {
+			continue
+		}
+
+		parts := strings.Split(lib.Name, ":")
+		key := strings.Join(parts[:3], ":")
+		if _, ok := variants[key]; !ok {
+			order = append(order, key)
+		}
+		variants[key] = append(variants[key], lib)
+	}
+
+	var selected []Library
+	for _, key := range order {
+		var fallback *Library
+		var match *Library
+		for i, lib := range variants[key] {
+			arch := nativesArch(nativesClassifierOf(lib.Name))
+			if arch == env.Arch && match == nil {
+				match = &variants[key][i]
+			}
+			if arch == "x86_64" && fallback == nil {
+				fallback = &variants[key][i]
+			}
+		}
+		if match == nil {
+			match = fallback
+		}
+		if match != nil {
+			selected = append(selected, *match)
+		}
+	}
+
+	return selected
+}
+
+func excluded(name string, exclude []string) bool {
+	for _, prefix := range exclude {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNativeLibrary(name string) bool {
+	for _, ext := range []string{".so", ".dll", ".dylib", ".jnilib"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractedNative records where an extracted file came from, so that the
+// same path in another jar can be told apart from a harmless duplicate.
+type extractedNative struct {
+	jar   string
+	crc32 uint32
+	size  uint64
+}
+
+// extractNatives extracts the native libraries in jarPath into outputDir,
+// keeping their paths inside the jar. extracted holds the files written by
+// earlier jars and is updated with this one's.
+func extractNatives(ctx context.Context, jarPath string, outputDir string, exclude []string, extracted map[string]extractedNative) error {
 	reader, err := zip.OpenReader(jarPath)
 	if err != nil {
-		log.Fatalf("Failed to open native jar: %s", err)
+		return fmt.Errorf("failed to open native jar: %w", err)
 	}
 	defer reader.Close()
 
 	for _, file := range reader.File {
-		if strings.HasPrefix(file.Name, "META-INF") || strings.HasSuffix(file.Name, ".class") {
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "META-INF/") || excluded(file.Name, exclude) {
 			continue
 		}
-
-		if !strings.HasSuffix(file.Name, ".so") &&
-			!strings.HasSuffix(file.Name, ".dll") &&
-			!strings.HasSuffix(file.Name, ".dylib") &&
-			!strings.HasSuffix(file.Name, ".jnilib") {
+		if !isNativeLibrary(file.Name) {
 			continue
 		}
 
-		rc, err := file.Open()
+		dest, err := safeJoin(outputDir, file.Name)
 		if err != nil {
-			log.Fatalf("Failed to open file in jar: %s", err)
+			return err
 		}
-		defer rc.Close()
 
-		dest := filepath.Join(outputDir, filepath.Base(file.Name))
-		out, err := os.Create(dest)
-		if err != nil {
-			log.Fatalf("Failed to create extracted native: %s", err)
+		if previous, ok := extracted[dest]; ok {
+			if previous.crc32 == file.CRC32 && previous.size == file.UncompressedSize64 {
+				continue
+			}
+			return fmt.Errorf("%w: %s is in both %s and %s", ErrNativeConflict, file.Name, filepath.Base(previous.jar), filepath.Base(jarPath))
 		}
-		defer out.Close()
 
-		_, err = io.Copy(out, rc)
+		err = extractNative(ctx, file, dest)
 		if err != nil {
-			log.Fatalf("Failed to copy native file: %s", err)
+			return fmt.Errorf("failed to extract %s from %s: %w", file.Name, filepath.Base(jarPath), err)
 		}
+		extracted[dest] = extractedNative{jar: jarPath, crc32: file.CRC32, size: file.UncompressedSize64}
 	}
+
+	return nil
 }
 
-func downloadNatives(meta *VersionMeta, baseDir string) {
-	currentOS := runtime.GOOS
-	outputDir := filepath.Join(baseDir, "natives", meta.ID)
-	_ = os.MkdirAll(outputDir, 0755)
+func extractNative(ctx context.Context, file *zip.File, dest string) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeExtracted(ctx, dest, rc, file.Mode().Perm())
+	if err != nil {
+		os.Remove(dest)
+	}
+	return err
+}
 
+// nativeJars lists the jars holding the natives of meta in env, in both the
+// legacy classifiers layout and the 1.19+ natives artifacts one.
+func nativeJars(meta *VersionMeta, baseDir string, env Environment) ([]nativeJar, error) {
+	var jars []nativeJar
 	for _, lib := range meta.Libraries {
-		if !isAllowed(lib.Rules) {
+		if !env.Allows(lib.Rules) {
 			continue
 		}
-		key := ""
-		switch currentOS {
-		case "windows":
-			key = lib.Natives.Windows
-		case "linux":
-			key = lib.Natives.Linux
-		case "darwin":
-			key = lib.Natives.Osx
-		}
+		key := env.nativesClassifier(lib)
 		if key == "" {
 			continue
 		}
@@ -82,22 +202,147 @@ func downloadNatives(meta *VersionMeta, baseDir string) {
 			continue
 		}
 
-		dest := filepath.Join(baseDir, "libraries", "natives", filepath.Base(nativeDownload.URL))
-		if fileExists(dest) && sha1Matches(dest, nativeDownload.SHA1) {
-			log.Printf("Native %s already downloaded", lib.Name)
-		} else {
-			resp, err := http.Get(nativeDownload.URL)
-			if err != nil {
-				log.Fatalf("Failed to download native: %s", err)
-			}
-			defer resp.Body.Close()
+		jars = append(jars, nativeJar{
+			task: downloadTask{
+				URL:  nativeDownload.URL,
+				Dest: filepath.Join(baseDir, "libraries", "natives", filepath.Base(nativeDownload.URL)),
+				SHA1: nativeDownload.SHA1,
+				Size: int64(nativeDownload.Size),
+			},
+			exclude: lib.excludes(),
+		})
+	}
+
+	// Since 1.19 natives are plain libraries with a natives-* classifier.
+	for _, lib := range nativeArtifacts(meta.Libraries, env) {
+		path, err := libraryPathFromName(lib.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		artifact := lib.Downloads.Artifact
+		jars = append(jars, nativeJar{
+			task: downloadTask{
+				URL:  artifact.URL,
+				Dest: filepath.Join(baseDir, "libraries", path),
+				SHA1: artifact.SHA1,
+				Size: int64(artifact.Size),
+			},
+			exclude: lib.excludes(),
+		})
+	}
+
+	return jars, nil
+}
+
+func downloadNatives(ctx context.Context, sched *scheduler, meta *VersionMeta, baseDir string) error {
+	jars, err := nativeJars(meta, baseDir, sched.env)
+	if err != nil {
+		return err
+	}
+
+	tasks := make([]downloadTask, len(jars))
+	for i, jar := range jars {
+		tasks[i] = jar.task
+	}
+
+	startPhase(sched.progress, PhaseNatives, len(tasks), tasksSize(tasks))
+	err = sched.run(ctx, PhaseNatives, tasks)
+	return finishPhase(sched.progress, PhaseNatives, err)
+}
+
+// nativesDir holds the natives directories of a version's launches.
+func nativesDir(baseDir string, version string) string {
+	return filepath.Join(baseDir, "natives", version)
+}
+
+// prepareNatives extracts the natives of meta into a new directory under
+// nativesDir and returns it. Each launch gets a directory of its own, since
+// games of the same version running side by side keep their libraries loaded
+// (and locked on Windows); the caller removes it once the game has exited.
+// Directories left behind by launchers that exited before their game are
+// pruned here.
+func prepareNatives(ctx context.Context, meta *VersionMeta, baseDir string, env Environment) (string, error) {
+	jars, err := nativeJars(meta, baseDir, env)
+	if err != nil {
+		return "", err
+	}
+
+	parent := nativesDir(baseDir, meta.ID)
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create natives directory: %w", err)
+	}
+	pruneNatives(parent)
+	outputDir, err := os.MkdirTemp(parent, "launch-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create natives directory: %w", err)
+	}
 
-			os.MkdirAll(filepath.Dir(dest), 0755)
-			out, _ := os.Create(dest)
-			io.Copy(out, resp.Body)
-			out.Close()
+	extracted := map[string]extractedNative{}
+	for _, jar := range jars {
+		err = extractNatives(ctx, jar.task.Dest, outputDir, jar.exclude, extracted)
+		if err != nil {
+			os.RemoveAll(outputDir)
+			return "", err
 		}
+	}
+
+	return outputDir, nil
+}
+
+// nativesPIDFile holds the PID of the game using a launch's natives directory.
+const nativesPIDFile = ".pid"
+
+// nativesGracePeriod is how long a natives directory without a PID is assumed
+// to belong to a launch that is still starting.
+const nativesGracePeriod = time.Minute
+
+func markNativesInUse(dir string, pid int) {
+	os.WriteFile(filepath.Join(dir, nativesPIDFile), []byte(strconv.Itoa(pid)), 0644)
+}
 
-		extractNatives(dest, outputDir)
+func nativesInUse(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, nativesPIDFile))
+	if err != nil {
+		info, err := os.Stat(dir)
+		return err == nil && time.Since(info.ModTime()) < nativesGracePeriod
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && processRunning(pid)
+}
+
+// pruneNatives removes the launch directories under parent whose game is no
+// longer running. It is best effort: on Windows the libraries of a game that
+// is still running cannot be removed and are left for a later launch.
+func pruneNatives(parent string) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(parent, entry.Name())
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "launch-") && !nativesInUse(dir) {
+			os.RemoveAll(dir)
+		}
 	}
-}
\ No newline at end of file
+}
+
+// nativeLibraryPath returns the java.library.path for a natives directory:
+// the directory itself followed by every subdirectory holding libraries, as
+// natives jars of LWJGL 3.3 keep theirs under e.g. linux/x64/org/lwjgl.
+func nativeLibraryPath(dir string) string {
+	paths := []string{dir}
+	seen := map[string]bool{dir: true}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isNativeLibrary(path) {
+			return nil
+		}
+		if parent := filepath.Dir(path); !seen[parent] {
+			seen[parent] = true
+			paths = append(paths, parent)
+		}
+		return nil
+	})
+	return strings.Join(paths, string(os.PathListSeparator))
+}
@@ -0,0 +1,172 @@
+package piston
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPruneNatives(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exited processes cannot be told apart from running ones")
+	}
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := t.TempDir()
+	dirs := map[string]bool{
+		"launch-running":  true,
+		"launch-exited":   false,
+		"launch-starting": true,
+		"launch-crashed":  false,
+		"other":           true,
+	}
+	for name := range dirs {
+		err := os.Mkdir(filepath.Join(parent, name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	markNativesInUse(filepath.Join(parent, "launch-running"), os.Getpid())
+	markNativesInUse(filepath.Join(parent, "launch-exited"), exited.Process.Pid)
+	old := time.Now().Add(-2 * nativesGracePeriod)
+	os.Chtimes(filepath.Join(parent, "launch-crashed"), old, old)
+
+	pruneNatives(parent)
+
+	for name, kept := range dirs {
+		_, err := os.Stat(filepath.Join(parent, name))
+		if kept != (err == nil) {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestPrepareNativesPrunesExitedLaunches(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exited processes cannot be told apart from running ones")
+	}
+
+	baseDir := t.TempDir()
+	meta := &VersionMeta{ID: "1.21"}
+	env := Environment{OS: "linux", Arch: "x86_64"}
+
+	first, err := prepareNatives(context.Background(), meta, baseDir, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process, err := startGame(exec.Command("sleep", "10"), "1.21", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Kill()
+
+	// The first game is still running, so its directory stays.
+	second, err := prepareNatives(context.Background(), meta, baseDir, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("two launches share a natives directory")
+	}
+	if _, err := os.Stat(first); err != nil {
+		t.Fatalf("natives of a running game were removed: %v", err)
+	}
+
+	// A game that exited without its launcher removing the directory.
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	markNativesInUse(second, exited.Process.Pid)
+	third, err := prepareNatives(context.Background(), meta, baseDir, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Error("natives of an exited launch were kept")
+	}
+	if _, err := os.Stat(third); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtractNativesRejectsEscapingEntries(t *testing.T) {
+	tmp := t.TempDir()
+	jar := filepath.Join(tmp, "evil.jar")
+	writeZip(t, jar, map[string]string{"../escaped.so": "evil"})
+
+	outputDir := filepath.Join(tmp, "natives")
+	err := extractNatives(context.Background(), jar, outputDir, nil, map[string]extractedNative{})
+	if err == nil {
+		t.Fatal("extracted an entry escaping the natives directory")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "escaped.so")); !os.IsNotExist(err) {
+		t.Error("escaped.so was written outside the natives directory")
+	}
+}
+
+func TestExtractNativesSkipsExcludedEntries(t *testing.T) {
+	tmp := t.TempDir()
+	jar := filepath.Join(tmp, "lwjgl-natives.jar")
+	writeZip(t, jar, map[string]string{
+		"META-INF/signed.so": "signature",
+		"skip/liba.so":       "a",
+		"liblwjgl.so":        "lwjgl",
+		"README.txt":         "not a native",
+	})
+
+	outputDir := filepath.Join(tmp, "natives")
+	err := extractNatives(context.Background(), jar, outputDir, []string{"skip/"}, map[string]extractedNative{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"META-INF/signed.so": false,
+		"skip/liba.so":       false,
+		"liblwjgl.so":        true,
+		"README.txt":         false,
+	} {
+		_, err := os.Stat(filepath.Join(outputDir, name))
+		if want != (err == nil) {
+			t.Errorf("%s extracted = %v, want %v", name, err == nil, want)
+		}
+	}
+}
+
+func TestExtractNativesDuplicates(t *testing.T) {
+	tmp := t.TempDir()
+	first := filepath.Join(tmp, "first.jar")
+	same := filepath.Join(tmp, "same.jar")
+	different := filepath.Join(tmp, "different.jar")
+	writeZip(t, first, map[string]string{"libopenal.so": "openal"})
+	writeZip(t, same, map[string]string{"libopenal.so": "openal"})
+	writeZip(t, different, map[string]string{"libopenal.so": "another openal"})
+
+	outputDir := filepath.Join(tmp, "natives")
+	extracted := map[string]extractedNative{}
+	for _, jar := range []string{first, same} {
+		err := extractNatives(context.Background(), jar, outputDir, nil, extracted)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(jar), err)
+		}
+	}
+
+	err := extractNatives(context.Background(), different, outputDir, nil, extracted)
+	if !errors.Is(err, ErrNativeConflict) {
+		t.Fatalf("err = %v, want ErrNativeConflict", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outputDir, "libopenal.so"))
+	if err != nil || string(data) != "openal" {
+		t.Errorf("libopenal.so = %q, %v; want the first jar's copy", data, err)
+	}
+}
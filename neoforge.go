@@ -0,0 +1,107 @@
+package piston
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const neoForgeMetadataURL = "https://" + NeoForgedMavenHost + "/releases/net/neoforged/neoforge/maven-metadata.xml"
+
+// neoForgePrefix returns the prefix shared by the NeoForge versions for
+// mcVersion: 1.20.2 is served by 20.2.x and 1.21 by 21.0.x.
+func neoForgePrefix(mcVersion string) string {
+	version, ok := strings.CutPrefix(mcVersion, "1.")
+	if !ok {
+		return mcVersion + "."
+	}
+	if !strings.Contains(version, ".") {
+		version += ".0"
+	}
+	return version + "."
+}
+
+func fetchNeoForgeVersions(ctx context.Context, sched *scheduler, mcVersion string) ([]string, error) {
+	var metadata MavenMetadata
+	err := sched.fetchCached(ctx, neoForgeMetadataURL, sched.manifestTTL, func(data []byte) error {
+		metadata = MavenMetadata{}
+		err := xml.Unmarshal(data, &metadata)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", neoForgeMetadataURL, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return neoForgeVersionsFor(metadata.Versioning.Versions, mcVersion), nil
+}
+
+// neoForgeVersionsFor keeps the versions that are builds for mcVersion.
+func neoForgeVersionsFor(versions []string, mcVersion string) []string {
+	prefix := neoForgePrefix(mcVersion)
+	var matching []string
+	for _, version := range versions {
+		if strings.HasPrefix(version, prefix) {
+			matching = append(matching, version)
+		}
+	}
+	return matching
+}
+
+// latestNeoForgeVersion returns the newest of versions, listed oldest first,
+// that is not a beta, or the newest beta when every build is one.
+func latestNeoForgeVersion(versions []string) string {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !strings.HasSuffix(versions[i], "-beta") {
+			return versions[i]
+		}
+	}
+	return versions[len(versions)-1]
+}
+
+func (launcher PistonLauncher) QueryNeoForgeVersions(mcVersion string) ([]string, error) {
+	return launcher.QueryNeoForgeVersionsContext(context.Background(), mcVersion)
+}
+
+// QueryNeoForgeVersionsContext lists the NeoForge builds published for
+// Minecraft mcVersion (1.20.2 or later), oldest first.
+func (launcher PistonLauncher) QueryNeoForgeVersionsContext(ctx context.Context, mcVersion string) ([]string, error) {
+	return fetchNeoForgeVersions(ctx, launcher.scheduler(), mcVersion)
+}
+
+func (launcher PistonLauncher) DownloadNeoForgeVersion(mcVersion string, neoVersion string) (*VersionMeta, error) {
+	return launcher.DownloadNeoForgeVersionContext(context.Background(), mcVersion, neoVersion)
+}
+
+// DownloadNeoForgeVersionContext installs NeoForge neoVersion for Minecraft
+// mcVersion by running its installer, and returns the version to launch,
+// e.g. neoforge-21.1.77. An empty neoVersion picks the latest stable build,
+// or the latest beta for a Minecraft version that only has betas.
+func (launcher PistonLauncher) DownloadNeoForgeVersionContext(ctx context.Context, mcVersion string, neoVersion string) (*VersionMeta, error) {
+	versions, err := fetchNeoForgeVersions(ctx, launcher.scheduler(), mcVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(versions) == 0:
+		return nil, fmt.Errorf("%w: no builds for %s", ErrNeoForgeNotFound, mcVersion)
+	case neoVersion == "":
+		neoVersion = latestNeoForgeVersion(versions)
+	case !slices.Contains(versions, neoVersion):
+		return nil, fmt.Errorf("%w: %s for %s", ErrNeoForgeNotFound, neoVersion, mcVersion)
+	}
+
+	path, err := libraryPathFromName("net.neoforged:neoforge:" + neoVersion + ":installer")
+	if err != nil {
+		return nil, err
+	}
+
+	url := "https://" + NeoForgedMavenHost + "/releases/" + path
+	return launcher.installWithInstaller(ctx, url, filepath.Join(launcher.BasePath, "libraries", path))
+}
@@ -0,0 +1,69 @@
+package piston
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNeoForgePrefix(t *testing.T) {
+	tests := []struct {
+		mcVersion string
+		want      string
+	}{
+		{"1.20.2", "20.2."},
+		{"1.20.6", "20.6."},
+		{"1.21", "21.0."},
+		{"1.21.1", "21.1."},
+		{"1.21.10", "21.10."},
+	}
+	for _, test := range tests {
+		got := neoForgePrefix(test.mcVersion)
+		if got != test.want {
+			t.Errorf("neoForgePrefix(%q) = %q, want %q", test.mcVersion, got, test.want)
+		}
+	}
+}
+
+func TestNeoForgeVersionsFor(t *testing.T) {
+	versions := []string{
+		"20.2.3-beta", "20.2.86", "20.4.237",
+		"21.0.0-beta", "21.0.167",
+		"21.1.1", "21.1.77",
+		"21.10.5-beta",
+	}
+
+	tests := []struct {
+		mcVersion string
+		want      []string
+	}{
+		{"1.20.2", []string{"20.2.3-beta", "20.2.86"}},
+		{"1.20.4", []string{"20.4.237"}},
+		{"1.21", []string{"21.0.0-beta", "21.0.167"}},
+		{"1.21.1", []string{"21.1.1", "21.1.77"}},
+		{"1.21.10", []string{"21.10.5-beta"}},
+		{"1.20.1", nil},
+	}
+	for _, test := range tests {
+		got := neoForgeVersionsFor(versions, test.mcVersion)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("versions for %s = %v, want %v", test.mcVersion, got, test.want)
+		}
+	}
+}
+
+func TestLatestNeoForgeVersion(t *testing.T) {
+	tests := []struct {
+		versions []string
+		want     string
+	}{
+		{[]string{"21.1.1", "21.1.77"}, "21.1.77"},
+		{[]string{"21.0.0-beta", "21.0.166", "21.0.167-beta"}, "21.0.166"},
+		{[]string{"21.10.0-beta", "21.10.5-beta"}, "21.10.5-beta"},
+	}
+	for _, test := range tests {
+		got := latestNeoForgeVersion(test.versions)
+		if got != test.want {
+			t.Errorf("latestNeoForgeVersion(%v) = %q, want %q", test.versions, got, test.want)
+		}
+	}
+}
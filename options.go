@@ -0,0 +1,134 @@
+package piston
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// JavaStrategy decides when the launcher provisions Java runtimes.
+type JavaStrategy int
+
+const (
+	// JavaLazy downloads a runtime the first time a version that needs it is
+	// launched.
+	JavaLazy JavaStrategy = iota
+	// JavaEager downloads JDK 8 and JDK 21 while the launcher is created.
+	JavaEager
+	// JavaManual never downloads anything; launching a version whose runtime
+	// has not been installed fails with ErrJavaNotFound.
+	JavaManual
+)
+
+type Option func(*PistonLauncher)
+
+func WithJavaStrategy(strategy JavaStrategy) Option {
+	return func(l *PistonLauncher) {
+		l.Java = strategy
+	}
+}
+
+func WithJavaProvider(provider JavaProvider) Option {
+	return func(l *PistonLauncher) {
+		l.JavaProvider = provider
+	}
+}
+
+// WithSystemJava makes the launcher prefer runtimes already installed on the
+// system over managed ones.
+func WithSystemJava(prefer bool) Option {
+	return func(l *PistonLauncher) {
+		l.PreferSystemJava = prefer
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(l *PistonLauncher) {
+		l.HTTPClient = client
+	}
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(l *PistonLauncher) {
+		l.UserAgent = userAgent
+	}
+}
+
+func WithEndpoints(endpoints Endpoints) Option {
+	return func(l *PistonLauncher) {
+		l.Endpoints = endpoints
+	}
+}
+
+// WithConcurrency sets how many files are downloaded in parallel overall and
+// per host.
+func WithConcurrency(workers int, perHost int) Option {
+	return func(l *PistonLauncher) {
+		l.Concurrency = workers
+		l.MaxConnsPerHost = perHost
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(l *PistonLauncher) {
+		l.Retry = policy
+	}
+}
+
+func WithProgress(reporter ProgressReporter) Option {
+	return func(l *PistonLauncher) {
+		l.Progress = reporter
+	}
+}
+
+func WithEnvironment(env Environment) Option {
+	return func(l *PistonLauncher) {
+		l.Environment = &env
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(l *PistonLauncher) {
+		l.Logger = logger
+	}
+}
+
+func WithManifestTTL(ttl time.Duration) Option {
+	return func(l *PistonLauncher) {
+		l.ManifestTTL = ttl
+	}
+}
+
+func WithOffline(offline bool) Option {
+	return func(l *PistonLauncher) {
+		l.Offline = offline
+	}
+}
+
+// NewLauncher creates a launcher storing everything under basePath. Unless
+// JavaEager is requested it does not touch the network.
+func NewLauncher(basePath string, opts ...Option) (*PistonLauncher, error) {
+	launcher := &PistonLauncher{BasePath: basePath}
+	for _, opt := range opts {
+		opt(launcher)
+	}
+
+	err := os.MkdirAll(basePath, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create base directory: %w", err)
+	}
+
+	if launcher.Java == JavaEager {
+		for _, version := range []uint16{8, 21} {
+			_, err = launcher.resolveJava(context.Background(), JavaVersion{MajorVersion: version})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return launcher, nil
+}
@@ -0,0 +1,127 @@
+package piston
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeJavaProvider records the calls made to it and installs runtimes that
+// only exist in its map.
+type fakeJavaProvider struct {
+	mu        sync.Mutex
+	installed map[uint16]string
+	calls     []string
+}
+
+func (p *fakeJavaProvider) record(call string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, call)
+}
+
+func (p *fakeJavaProvider) Find(launcher *PistonLauncher, java JavaVersion) string {
+	p.record("find")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.installed[java.MajorVersion]
+}
+
+func (p *fakeJavaProvider) Install(ctx context.Context, launcher *PistonLauncher, java JavaVersion) error {
+	p.record("install")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.installed == nil {
+		p.installed = map[uint16]string{}
+	}
+	p.installed[java.MajorVersion] = filepath.Join(launcher.BasePath, "fake", "java")
+	return nil
+}
+
+func (p *fakeJavaProvider) Remove(launcher *PistonLauncher, java JavaVersion) error {
+	p.record("remove")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.installed, java.MajorVersion)
+	return nil
+}
+
+func (p *fakeJavaProvider) count(call string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, c := range p.calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+type failingTransport struct{ t *testing.T }
+
+func (f failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request for %s", r.URL)
+	return nil, errors.New("no network in this test")
+}
+
+func TestNewLauncherIsLazy(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "piston")
+	provider := &fakeJavaProvider{}
+	client := &http.Client{Transport: failingTransport{t}}
+
+	launcher, err := NewLauncher(basePath,
+		WithJavaProvider(provider),
+		WithHTTPClient(client),
+		WithConcurrency(4, 2),
+		WithUserAgent("test/1.0"),
+		WithOffline(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if launcher.BasePath != basePath || launcher.Java != JavaLazy || launcher.JavaProvider != provider || launcher.HTTPClient != client {
+		t.Errorf("launcher = %+v", launcher)
+	}
+	if launcher.Concurrency != 4 || launcher.MaxConnsPerHost != 2 || launcher.UserAgent != "test/1.0" || !launcher.Offline {
+		t.Errorf("options not applied: %+v", launcher)
+	}
+	if info, err := os.Stat(basePath); err != nil || !info.IsDir() {
+		t.Errorf("BasePath was not created: %v", err)
+	}
+	if len(provider.calls) != 0 {
+		t.Errorf("the Java provider was called: %v", provider.calls)
+	}
+}
+
+func TestNewLauncherEager(t *testing.T) {
+	provider := &fakeJavaProvider{}
+	_, err := NewLauncher(t.TempDir(), WithJavaProvider(provider), WithJavaStrategy(JavaEager))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.count("install") != 2 || provider.installed[8] == "" || provider.installed[21] == "" {
+		t.Errorf("installed %v, want Java 8 and 21", provider.installed)
+	}
+}
+
+func TestJavaManualDoesNotInstall(t *testing.T) {
+	provider := &fakeJavaProvider{}
+	launcher, err := NewLauncher(t.TempDir(), WithJavaProvider(provider), WithJavaStrategy(JavaManual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = launcher.javaFor(context.Background(), &VersionMeta{JavaVersion: &JavaVersion{MajorVersion: 17}})
+	if !errors.Is(err, ErrJavaNotFound) {
+		t.Errorf("err = %v, want ErrJavaNotFound", err)
+	}
+	if provider.count("install") != 0 {
+		t.Errorf("JavaManual installed a runtime: %v", provider.calls)
+	}
+}
@@ -1,141 +1,420 @@
 package piston
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 )
 
 type PistonLauncher struct {
 	BasePath string
-	JDK8e    string
-	JDK21e   string
+
+	// JavaPaths maps a Java major version onto the java executable to use
+	// for it, taking precedence over the runtimes managed under BasePath as
+	// long as it runs and reports that version.
+	JavaPaths map[uint16]string
+
+	// Concurrency is the number of files an install downloads in parallel
+	// and MaxConnsPerHost caps how many requests may target the same host.
+	// Zero values fall back to DefaultConcurrency and DefaultMaxConnsPerHost.
+	// The host cap counts the requests of every concurrent install into
+	// BasePath, but each launcher's requests only wait on its own cap.
+	Concurrency     int
+	MaxConnsPerHost int
+
+	// Progress, when set, receives events for every install phase and file.
+	Progress ProgressReporter
+
+	// Retry is applied to every download. The zero value means
+	// DefaultRetryPolicy.
+	Retry RetryPolicy
+
+	// HTTPClient is used for every request, defaulting to http.DefaultClient.
+	// Endpoints redirects hosts to mirrors, and UserAgent defaults to
+	// DefaultUserAgent.
+	HTTPClient *http.Client
+	Endpoints  Endpoints
+	UserAgent  string
+
+	// Version manifests are cached under BasePath/cache and refetched once
+	// they are older than ManifestTTL (DefaultManifestTTL when zero). In
+	// Offline mode nothing is fetched: queries are answered from the cache
+	// and installs only succeed when every file is already present.
+	ManifestTTL time.Duration
+	Offline     bool
+
+	// Java decides when runtimes are downloaded; see JavaStrategy.
+	// JavaProvider decides where from, defaulting to AdoptiumProvider.
+	// With PreferSystemJava, a runtime found by DiscoverJava is used when its
+	// major version matches, before falling back to the provider. Candidates
+	// are probed until one matches, and each is only run once per process.
+	Java             JavaStrategy
+	JavaProvider     JavaProvider
+	PreferSystemJava bool
+
+	// Environment, when set, replaces HostEnvironment as what the rules in
+	// version JSONs are evaluated against, e.g. to install the libraries of
+	// another platform.
+	Environment *Environment
+
+	// Logger receives the launcher's messages and the game's output,
+	// defaulting to the standard logger.
+	Logger *log.Logger
 }
 
+// CreatePistonLauncher creates a launcher and downloads JDK 8 and JDK 21
+// before returning, logging any failure.
+//
+// Deprecated: use NewLauncher, which resolves Java lazily and reports errors.
 func CreatePistonLauncher(BasePath string) PistonLauncher {
-	jdk8path := filepath.Join(BasePath, "java", "jdk-" + fmt.Sprint(8))
-	jdk21path := filepath.Join(BasePath, "java", "jdk-" + fmt.Sprint(21))
-
-	is8Installed := pathExists(jdk8path)
-	is21Installed := pathExists(jdk21path)
+	launcher := PistonLauncher{BasePath: BasePath, Java: JavaEager}
 
-	if !is8Installed {
-		err := downloadJDK(BasePath, 8)
+	for _, version := range []uint16{8, 21} {
+		path, err := launcher.resolveJava(context.Background(), JavaVersion{MajorVersion: version})
 		if err != nil {
-        	log.Printf("Failed to download JDK 8: %v", err)
-    	}
+			launcher.logger().Printf("Failed to download JDK %d: %v", version, err)
+			continue
+		}
+
+		if launcher.JavaPaths == nil {
+			launcher.JavaPaths = map[uint16]string{}
+		}
+		launcher.JavaPaths[version] = path
 	}
 
-	if !is21Installed {
-		err := downloadJDK(BasePath, 21)
-		if err != nil {
-        	log.Printf("Failed to download JDK 21: %v", err)
-    	}
+	return launcher
+}
+
+func (launcher PistonLauncher) environment() Environment {
+	if launcher.Environment != nil {
+		return *launcher.Environment
 	}
+	return HostEnvironment()
+}
 
-	return PistonLauncher{
-		BasePath: BasePath,
-		JDK8e: findJavaExecutable(jdk8path),
-		JDK21e: findJavaExecutable(jdk21path),
+func (launcher PistonLauncher) logger() *log.Logger {
+	if launcher.Logger != nil {
+		return launcher.Logger
 	}
+	return log.Default()
 }
 
 func (launcher PistonLauncher) IsJavaInstalled(version uint16) bool {
-	return pathExists(filepath.Join(launcher.BasePath, "java", "jdk-" + fmt.Sprint(version)))
+	return launcher.javaProvider().Find(&launcher, JavaVersion{MajorVersion: version}) != ""
+}
+
+func (launcher PistonLauncher) DownloadJDK8() error {
+	return launcher.DownloadJDKContext(context.Background(), 8)
+}
+
+func (launcher PistonLauncher) DownloadJDK21() error {
+	return launcher.DownloadJDKContext(context.Background(), 21)
+}
+
+// DownloadJDKContext installs the runtime for the given Java major version
+// through the launcher's JavaProvider.
+func (launcher PistonLauncher) DownloadJDKContext(ctx context.Context, version uint16) error {
+	return launcher.javaProvider().Install(ctx, &launcher, JavaVersion{MajorVersion: version})
+}
+
+func (launcher PistonLauncher) QueryVersions() (*VersionManifest, error) {
+	return launcher.QueryVersionsContext(context.Background())
 }
 
-func (launcher PistonLauncher) DownloadJDK8() {
-	downloadJDK(launcher.BasePath, 8)
+func (launcher PistonLauncher) QueryVersionsContext(ctx context.Context) (*VersionManifest, error) {
+	return fetchManifest(ctx, launcher.scheduler(), false)
 }
 
-func (launcher PistonLauncher) DownloadJDK21() {
-	downloadJDK(launcher.BasePath, 21)
+// RefreshVersions is like QueryVersions but ignores ManifestTTL and always
+// asks the server for the current version list, unless the launcher is
+// offline.
+func (launcher PistonLauncher) RefreshVersions() (*VersionManifest, error) {
+	return launcher.RefreshVersionsContext(context.Background())
 }
 
-func (launcher PistonLauncher) QueryVersions() *VersionManifest {
-	return fetchManifest()
+func (launcher PistonLauncher) RefreshVersionsContext(ctx context.Context) (*VersionManifest, error) {
+	return fetchManifest(ctx, launcher.scheduler(), true)
 }
 
-func (launcher PistonLauncher) DownloadVersion(url string) *VersionMeta {
-	meta := fetchVersionManifest(url)
+// IsVersionInstalled reports whether version was installed completely: its
+// version JSON is only written once every file it needs is in place.
+func (launcher PistonLauncher) IsVersionInstalled(version string) bool {
+	versionDir := filepath.Join(launcher.BasePath, "versions", version)
+	return fileExists(filepath.Join(versionDir, version+".json")) && fileExists(filepath.Join(versionDir, version+".jar"))
+}
+
+func (launcher PistonLauncher) InstalledVersions() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(launcher.BasePath, "versions"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions directory: %w", err)
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() && launcher.IsVersionInstalled(entry.Name()) {
+			versions = append(versions, entry.Name())
+		}
+	}
+
+	return versions, nil
+}
+
+func (launcher PistonLauncher) DownloadVersion(url string) (*VersionMeta, error) {
+	return launcher.DownloadVersionContext(context.Background(), url)
+}
+
+// DownloadVersionContext is like DownloadVersion but stops as soon as ctx is
+// cancelled, removing any file that was only partially written.
+func (launcher PistonLauncher) DownloadVersionContext(ctx context.Context, url string) (*VersionMeta, error) {
+	sched := launcher.scheduler()
+
+	startPhase(launcher.Progress, PhaseManifest, 1, 0)
+	meta, err := fetchVersionManifest(ctx, sched, url, "")
+	if finishPhase(launcher.Progress, PhaseManifest, err) != nil {
+		return nil, err
+	}
+
+	err = launcher.installVersion(ctx, sched, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (launcher PistonLauncher) DownloadFabricVersion(url string, version string, loader string) (*VersionMeta, error) {
+	return launcher.DownloadFabricVersionContext(context.Background(), url, version, loader)
+}
+
+func (launcher PistonLauncher) DownloadFabricVersionContext(ctx context.Context, url string, version string, loader string) (*VersionMeta, error) {
+	sched := launcher.scheduler()
+
+	startPhase(launcher.Progress, PhaseManifest, 2, 0)
+	meta, err := fetchVersionManifest(ctx, sched, url, "")
+	if err != nil {
+		return nil, finishPhase(launcher.Progress, PhaseManifest, err)
+	}
+
+	fabricMeta, err := fetchFabricLoaderMeta(ctx, sched, version, loader)
+	if finishPhase(launcher.Progress, PhaseManifest, err) != nil {
+		return nil, err
+	}
+
+	meta, err = fabricMeta.patchVersionManifest(meta)
+	if err != nil {
+		return nil, err
+	}
 
-	downloadClientJar(meta, launcher.BasePath)
-	for _, lib := range meta.Libraries {
-		downloadLibrary(lib, launcher.BasePath)
+	err = launcher.installVersion(ctx, sched, meta)
+	if err != nil {
+		return nil, err
 	}
-	downloadNatives(meta, launcher.BasePath)
-	downloadAssets(meta, launcher.BasePath)
 
-	return meta
+	return meta, nil
 }
 
-func (launcher PistonLauncher) DownloadFabricVersion(url string, version string, loader string) *VersionMeta {
-	meta := fetchVersionManifest(url)
-	fabricMeta := fetchFabricLoaderMeta(version, loader)
+// installVersion downloads everything meta needs and then writes its version
+// JSON, which marks the version as installed.
+func (launcher PistonLauncher) installVersion(ctx context.Context, sched *scheduler, meta *VersionMeta) error {
+	err := launcher.downloadVersionFiles(ctx, sched, meta)
+	if err != nil {
+		return err
+	}
+	return saveVersionManifest(meta, launcher.BasePath)
+}
 
-	if fabricMeta == nil {
-		log.Fatalf("Couldn't find %s with loader %s.", version, loader)
+// downloadVersionFiles downloads the client jar, libraries, natives and assets
+// of meta.
+func (launcher PistonLauncher) downloadVersionFiles(ctx context.Context, sched *scheduler, meta *VersionMeta) error {
+	err := downloadClientJar(ctx, sched, meta, launcher.BasePath)
+	if err != nil {
+		return err
 	}
+	err = downloadLibraries(ctx, sched, meta, launcher.BasePath)
+	if err != nil {
+		return err
+	}
+	err = downloadNatives(ctx, sched, meta, launcher.BasePath)
+	if err != nil {
+		return err
+	}
+
+	return downloadAssets(ctx, sched, meta, launcher.BasePath)
+}
+
+func (launcher PistonLauncher) scheduler() *scheduler {
+	return newScheduler(launcher)
+}
 
-	meta = fabricMeta.patchVersionManifest(meta)
+// LaunchVersion launches version and blocks until the game exits, writing its
+// output to the launcher's logger.
+//
+// Deprecated: use Launch with LaunchOptions.
+func (launcher PistonLauncher) LaunchVersion(version string, xmx uint32, username string, accessToken string, uuid string, userType string, clientId string, versionType string) error {
+	return launcher.LaunchVersionContext(context.Background(), version, xmx, username, accessToken, uuid, userType, clientId, versionType)
+}
 
-	downloadClientJar(meta, launcher.BasePath)
-	for _, lib := range meta.Libraries {
-		downloadLibrary(lib, launcher.BasePath)
+// LaunchVersionContext is like LaunchVersion but kills the game process when
+// ctx is cancelled.
+//
+// Deprecated: use Launch with LaunchOptions.
+func (launcher PistonLauncher) LaunchVersionContext(ctx context.Context, version string, xmx uint32, username string, accessToken string, uuid string, userType string, clientId string, versionType string) error {
+	cmd, natives, err := launcher.gameCommand(ctx, version, LaunchOptions{
+		Account: Account{
+			Username:    username,
+			UUID:        uuid,
+			AccessToken: accessToken,
+			UserType:    userType,
+			ClientID:    clientId,
+		},
+		MaxMemory:   xmx,
+		VersionType: "piston.go-" + versionType,
+	})
+	if err != nil {
+		return err
 	}
-	downloadNatives(meta, launcher.BasePath)
-	downloadAssets(meta, launcher.BasePath)
+	cmd.Stdout = launcher.logger().Writer()
+	cmd.Stderr = launcher.logger().Writer()
 
-	return meta
+	process, err := startGame(cmd, version, natives)
+	if err != nil {
+		return err
+	}
+	return process.Wait()
+}
+
+// Launch starts the game and returns without waiting for it to exit. The
+// game's output goes to opts.Stdout and opts.Stderr, or is buffered on the
+// returned process when they are nil. Cancelling ctx kills the game.
+func (launcher PistonLauncher) Launch(ctx context.Context, version string, opts LaunchOptions) (*GameProcess, error) {
+	cmd, natives, err := launcher.gameCommand(ctx, version, opts)
+	if err != nil {
+		return nil, err
+	}
+	return startGame(cmd, version, natives)
 }
 
-func (launcher PistonLauncher) LaunchVersion(version string, xmx uint32, username string, accessToken string, uuid string, userType string, clientId string, versionType string) {
+// gameCommand builds the command launching version and returns it with the
+// launch's natives directory, which startGame removes once the game exited.
+func (launcher PistonLauncher) gameCommand(ctx context.Context, version string, opts LaunchOptions) (*exec.Cmd, string, error) {
 	meta, err := loadVersionManifest(launcher.BasePath, version)
 	if err != nil {
-		log.Fatalf("Failed to load version: %s", err)
+		return nil, "", err
 	}
 
-	vars := map[string]string{
-		"auth_player_name":    username,
-		"version_name":        version,
-		"game_directory":      launcher.BasePath,
-		"assets_root":         filepath.Join(launcher.BasePath, "assets"),
-		"game_assets":         filepath.Join(launcher.BasePath, "assets"),
-		"assets_index_name":   meta.AssetIndex.ID,
-		"auth_access_token":   accessToken,
-		"auth_uuid":           uuid,
-		"user_type":           userType,
-		"clientid":            clientId,
-		"version_type":        "piston.go-" + versionType,
-		"user_properties":      "{}",
+	err = os.MkdirAll(opts.gameDir(launcher.BasePath), 0755)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create game directory: %w", err)
 	}
 
-	log.Println("Launching Minecraft...")
+	// Java may have to be downloaded first, which must not happen while the
+	// natives directory is unclaimed and other launches could prune it.
+	jdk := opts.JavaPath
+	if jdk == "" {
+		jdk, err = launcher.javaFor(ctx, meta)
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
-	args := buildLaunchCommand(meta, launcher.BasePath, vars, xmx)
+	vars := opts.launchVars(meta, launcher.BasePath)
 
-	isOlder := requiresJDK8(version)
+	launcher.logger().Println("Launching Minecraft...")
 
-	var jdk string
+	env := launcher.environment()
+	natives, err := prepareNatives(ctx, meta, launcher.BasePath, env)
+	if err != nil {
+		return nil, "", err
+	}
 
-	if isOlder {
-		jdk = launcher.JDK8e
-	} else {
-		jdk = launcher.JDK21e
+	args, err := buildLaunchCommand(meta, launcher.BasePath, natives, vars, opts, env)
+	if err != nil {
+		os.RemoveAll(natives)
+		return nil, "", err
 	}
 
-	cmd := exec.Command(jdk, args...)
-	cmd.Stdout = log.Writer()
-	cmd.Stderr = log.Writer()
-	cmd.Stdin = nil
+	cmd := exec.CommandContext(ctx, jdk, args...)
+	cmd.Dir = opts.workingDir(launcher.BasePath)
+	cmd.Env = opts.environ()
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	return cmd, natives, nil
+}
+
+// javaFor returns the java executable for the major version declared in
+// meta's javaVersion.
+func (launcher PistonLauncher) javaFor(ctx context.Context, meta *VersionMeta) (string, error) {
+	java := JavaVersion{MajorVersion: javaMajor(meta)}
+	if meta.JavaVersion != nil {
+		java.Component = meta.JavaVersion.Component
+	}
+
+	// Pinned runtimes are repaired like managed ones: one that no longer
+	// starts is replaced by the provider's, unless nothing may be installed.
+	if path := launcher.JavaPaths[java.MajorVersion]; path != "" {
+		err := verifyJavaAt(ctx, path, java.MajorVersion)
+		if err == nil || launcher.Java == JavaManual || !errors.Is(err, ErrJavaBroken) {
+			return path, err
+		}
+		launcher.logger().Printf("Not using Java %d at %s: %v", java.MajorVersion, path, err)
+	}
 
-	err = cmd.Run()
+	if launcher.PreferSystemJava {
+		install, ok, err := findSystemJava(ctx, java.MajorVersion)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			launcher.logger().Printf("Using system Java %s (%s) at %s", install.Version, install.Vendor, install.Path)
+			return install.Path, nil
+		}
+	}
+
+	return launcher.resolveJava(ctx, java)
+}
+
+// resolveJava returns the java executable of the runtime for java from the
+// configured provider, installing it first unless the launcher uses
+// JavaManual. An installed runtime that fails to run is repaired the same way.
+func (launcher PistonLauncher) resolveJava(ctx context.Context, java JavaVersion) (string, error) {
+	provider := launcher.javaProvider()
+	if path := provider.Find(&launcher, java); path != "" {
+		err := verifyJavaAt(ctx, path, java.MajorVersion)
+		if err == nil || launcher.Java == JavaManual || !errors.Is(err, ErrJavaBroken) {
+			return path, err
+		}
+
+		// A runtime that no longer starts is reinstalled from scratch.
+		launcher.logger().Printf("Reinstalling Java %d: %v", java.MajorVersion, err)
+		err = provider.Remove(&launcher, java)
+		if err != nil {
+			return "", fmt.Errorf("failed to remove broken Java %d: %w", java.MajorVersion, err)
+		}
+	} else if launcher.Java == JavaManual {
+		return "", fmt.Errorf("%w: Java %d is not installed", ErrJavaNotFound, java.MajorVersion)
+	}
+
+	err := provider.Install(ctx, &launcher, java)
 	if err != nil {
-		log.Fatalf("Minecraft process failed: %s", err)
+		return "", err
+	}
+
+	path := provider.Find(&launcher, java)
+	if path == "" {
+		return "", fmt.Errorf("%w: Java %d after installing it", ErrJavaNotFound, java.MajorVersion)
 	}
+	return path, nil
 }
 
 func (launcher PistonLauncher) GenerateOfflineUUID(username string) string {
@@ -0,0 +1,215 @@
+package piston
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMirror serves files by path for every host the launcher talks to.
+type testMirror struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	requests map[string]int
+}
+
+func newTestMirror(t *testing.T) *testMirror {
+	m := &testMirror{files: map[string][]byte{}, requests: map[string]int{}}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.requests[r.URL.Path]++
+		data, ok := m.files[r.URL.Path]
+		m.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// add serves data at path on host and returns its URL.
+func (m *testMirror) add(host string, path string, data []byte) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path] = data
+	return "https://" + host + path
+}
+
+// file returns the data served at path.
+func (m *testMirror) file(path string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files[path]
+}
+
+func (m *testMirror) remove(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, path)
+}
+
+// served returns how many times path was requested.
+func (m *testMirror) served(path string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests[path]
+}
+
+func (m *testMirror) launcher(t *testing.T) PistonLauncher {
+	endpoints := Endpoints{}
+	for _, host := range []string{MojangMetaHost, MojangLauncherMetaHost, MojangDataHost, MojangLibrariesHost, MojangResourcesHost, ForgeMavenHost, NeoForgedMavenHost} {
+		endpoints[host] = m.URL
+	}
+	return PistonLauncher{
+		BasePath:  t.TempDir(),
+		Endpoints: endpoints,
+		Retry:     RetryPolicy{MaxAttempts: 1},
+		Logger:    log.New(io.Discard, "", 0),
+	}
+}
+
+const testAsset = "asset"
+
+// addVersion serves a vanilla version with a client jar and one asset and
+// returns the URL of its JSON.
+func (m *testMirror) addVersion(t *testing.T, id string) string {
+	t.Helper()
+	client := []byte("client " + id)
+	clientURL := m.add(MojangDataHost, "/v1/objects/"+sha1Hex(client)+"/client.jar", client)
+
+	asset := []byte(testAsset)
+	hash := sha1Hex(asset)
+	m.add(MojangResourcesHost, "/"+hash[:2]+"/"+hash, asset)
+
+	index, _ := json.Marshal(AssetIndexFile{Objects: map[string]AssetObject{"a.txt": {Hash: hash, Size: len(asset)}}})
+	indexURL := m.add(MojangMetaHost, "/v1/packages/"+sha1Hex(index)+"/"+id+"-assets.json", index)
+
+	data, err := json.Marshal(VersionMeta{
+		ID:         id,
+		Downloads:  map[string]Download{"client": {URL: clientURL, SHA1: sha1Hex(client), Size: len(client)}},
+		AssetIndex: AssetIndex{ID: id, URL: indexURL, SHA1: sha1Hex(index), Size: len(index)},
+		MainClass:  "net.minecraft.client.main.Main",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.add(MojangMetaHost, fmt.Sprintf("/v1/packages/%s/%s.json", sha1Hex(data), id), data)
+}
+
+func TestInterruptedInstallIsNotInstalled(t *testing.T) {
+	m := newTestMirror(t)
+	url := m.addVersion(t, "1.21")
+	launcher := m.launcher(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hash := sha1Hex([]byte(testAsset))
+	m.remove("/" + hash[:2] + "/" + hash)
+	_, err := launcher.DownloadVersionContext(ctx, url)
+	if err == nil {
+		t.Fatal("install succeeded without its asset")
+	}
+	if launcher.IsVersionInstalled("1.21") {
+		t.Error("a version whose assets failed to download is installed")
+	}
+
+	m.add(MojangResourcesHost, "/"+hash[:2]+"/"+hash, []byte(testAsset))
+	_, err = launcher.DownloadVersionContext(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := launcher.InstalledVersions()
+	if err != nil || !slices.Equal(versions, []string{"1.21"}) {
+		t.Errorf("InstalledVersions = %v, %v", versions, err)
+	}
+}
+
+func TestDownloadVersionVerifiesDocuments(t *testing.T) {
+	m := newTestMirror(t)
+	url := m.addVersion(t, "1.21")
+	launcher := m.launcher(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	versionPath := strings.TrimPrefix(url, "https://"+MojangMetaHost)
+	version := m.file(versionPath)
+	var meta VersionMeta
+	err := json.Unmarshal(version, &meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexPath := strings.TrimPrefix(meta.AssetIndex.URL, "https://"+MojangMetaHost)
+	index := m.file(indexPath)
+	indexFile := filepath.Join(launcher.BasePath, "assets", "indexes", "1.21.json")
+
+	corrupt := func(data []byte) []byte {
+		data = bytes.Clone(data)
+		data[len(data)/2] ^= 1
+		return data
+	}
+
+	m.add(MojangMetaHost, versionPath, corrupt(version))
+	_, err = launcher.DownloadVersionContext(ctx, url)
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("install of a tampered version JSON: err = %v, want a *ChecksumError", err)
+	}
+
+	m.add(MojangMetaHost, versionPath, version)
+	m.add(MojangMetaHost, indexPath, corrupt(index))
+	_, err = launcher.DownloadVersionContext(ctx, url)
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("install of a tampered asset index: err = %v, want a *ChecksumError", err)
+	}
+	if _, err := os.Stat(indexFile); !os.IsNotExist(err) {
+		t.Errorf("the tampered asset index was written: %v", err)
+	}
+	if launcher.IsVersionInstalled("1.21") {
+		t.Error("a version whose asset index failed to verify is installed")
+	}
+
+	m.add(MojangMetaHost, indexPath, index)
+	_, err = launcher.DownloadVersionContext(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damaged local copies are downloaded again rather than used.
+	err = os.WriteFile(indexFile, corrupt(index), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionServed, indexServed := m.served(versionPath), m.served(indexPath)
+	_, err = launcher.DownloadVersionContext(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.served(versionPath) != versionServed {
+		t.Error("the verified cached version JSON was fetched again")
+	}
+	if m.served(indexPath) != indexServed+1 {
+		t.Error("the damaged asset index was not fetched again")
+	}
+	data, err := os.ReadFile(indexFile)
+	if err != nil || !bytes.Equal(data, index) {
+		t.Errorf("asset index = %q, %v; want %q", data, err, index)
+	}
+}
@@ -0,0 +1,192 @@
+package piston
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// gameOutputLimit is how much unread output a GameProcess buffers per stream
+// before dropping the oldest.
+const gameOutputLimit = 1 << 20
+
+// GameProcess is a running Minecraft client started by Launch.
+type GameProcess struct {
+	Version   string
+	StartTime time.Time
+
+	// Stdout and Stderr stream the game's output unless LaunchOptions
+	// redirected it, in which case they are nil. Reading them is optional:
+	// up to gameOutputLimit bytes of unread output are kept per stream and
+	// older output is dropped. Both reach EOF once the process has exited and
+	// everything buffered was read.
+	Stdout io.Reader
+	Stderr io.Reader
+
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// startGame starts cmd and waits for it in the background. When cmd has no
+// Stdout or Stderr of its own, they are exposed on the returned process.
+// The natives directory of the launch is marked with the game's PID and
+// removed once the game has exited, or right away if it fails to start.
+func startGame(cmd *exec.Cmd, version string, natives string) (*GameProcess, error) {
+	process := &GameProcess{Version: version, cmd: cmd, done: make(chan struct{})}
+
+	var buffers []*outputBuffer
+	if cmd.Stdout == nil {
+		buf := newOutputBuffer(gameOutputLimit)
+		cmd.Stdout, process.Stdout = buf, buf
+		buffers = append(buffers, buf)
+	}
+	if cmd.Stderr == nil {
+		buf := newOutputBuffer(gameOutputLimit)
+		cmd.Stderr, process.Stderr = buf, buf
+		buffers = append(buffers, buf)
+	}
+
+	err := cmd.Start()
+	if err != nil {
+		os.RemoveAll(natives)
+		return nil, fmt.Errorf("failed to start minecraft: %w", err)
+	}
+	process.StartTime = time.Now()
+	markNativesInUse(natives, cmd.Process.Pid)
+
+	go func() {
+		err := cmd.Wait()
+		os.RemoveAll(natives)
+		for _, buf := range buffers {
+			buf.Close()
+		}
+		if err != nil {
+			process.err = fmt.Errorf("minecraft process failed: %w", err)
+		}
+		close(process.done)
+	}()
+
+	return process, nil
+}
+
+func (p *GameProcess) PID() int {
+	return p.cmd.Process.Pid
+}
+
+// Wait blocks until the game exits. It returns nil when the game exited
+// normally and an error wrapping *exec.ExitError when it crashed or was
+// killed.
+func (p *GameProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Done is closed once the game has exited.
+func (p *GameProcess) Done() <-chan struct{} {
+	return p.done
+}
+
+// Running reports whether the game has not exited yet.
+func (p *GameProcess) Running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// ExitCode returns the game's exit code, or -1 while it is still running or
+// when it was terminated by a signal.
+func (p *GameProcess) ExitCode() int {
+	if p.Running() {
+		return -1
+	}
+	return p.cmd.ProcessState.ExitCode()
+}
+
+// Kill terminates the game immediately. Killing a game that already exited
+// is not an error.
+func (p *GameProcess) Kill() error {
+	err := p.cmd.Process.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
+
+// outputBuffer carries a stream of the game's output to its reader. Writes
+// never block, so the game and cmd.Wait do not depend on anyone reading;
+// once more than limit bytes are unread, the oldest are discarded.
+type outputBuffer struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	buf    []byte
+	limit  int
+	closed bool
+}
+
+func newOutputBuffer(limit int) *outputBuffer {
+	b := &outputBuffer{limit: limit}
+	b.cond.L = &b.mu
+	return b
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		n := copy(b.buf, b.buf[len(b.buf)-b.limit:])
+		b.buf = b.buf[:n]
+	}
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// Read blocks until output is available and returns io.EOF once the buffer
+// is closed and empty.
+func (b *outputBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.buf) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *outputBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+// processRunning reports whether a process with pid exists.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// On Windows FindProcess only succeeds for a process that exists.
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,139 @@
+package piston
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is not a test but the game started by the process tests:
+// it writes the requested amount of output and exits with the requested code,
+// or sleeps until it is killed.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("PISTON_HELPER_PROCESS") != "1" {
+		t.Skip("only run as a helper process")
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	switch args[1] {
+	case "output":
+		size, _ := strconv.Atoi(args[2])
+		os.Stdout.Write(bytes.Repeat([]byte("o"), size-len("tail")))
+		os.Stdout.Write([]byte("tail"))
+		os.Stderr.Write([]byte("err"))
+		code, _ := strconv.Atoi(args[3])
+		os.Exit(code)
+	case "sleep":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+func startHelper(t *testing.T, args ...string) (*GameProcess, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "PISTON_HELPER_PROCESS=1")
+
+	natives := filepath.Join(t.TempDir(), "launch-test")
+	err := os.Mkdir(natives, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	process, err := startGame(cmd, "1.21", natives)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { process.Kill() })
+	return process, natives
+}
+
+func waitExited(t *testing.T, process *GameProcess) {
+	t.Helper()
+	select {
+	case <-process.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the game did not exit")
+	}
+}
+
+func TestGameProcessExit(t *testing.T) {
+	// Twice the buffered output, none of it read while the game runs.
+	process, natives := startHelper(t, "output", strconv.Itoa(2*gameOutputLimit), "3")
+	waitExited(t, process)
+
+	var exitErr *exec.ExitError
+	if err := process.Wait(); !errors.As(err, &exitErr) {
+		t.Errorf("Wait() = %v, want an *exec.ExitError", err)
+	}
+	if process.Running() || process.ExitCode() != 3 {
+		t.Errorf("Running() = %v, ExitCode() = %d; want false, 3", process.Running(), process.ExitCode())
+	}
+	if _, err := os.Stat(natives); !os.IsNotExist(err) {
+		t.Error("the natives directory outlived the game")
+	}
+	if err := process.Kill(); err != nil {
+		t.Errorf("Kill() after exit = %v", err)
+	}
+
+	stdout, err := io.ReadAll(process.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stdout) != gameOutputLimit || !bytes.HasSuffix(stdout, []byte("tail")) {
+		t.Errorf("read %d bytes of stdout, want the last %d", len(stdout), gameOutputLimit)
+	}
+	stderr, err := io.ReadAll(process.Stderr)
+	if err != nil || string(stderr) != "err" {
+		t.Errorf("stderr = %q, %v", stderr, err)
+	}
+}
+
+func TestGameProcessKill(t *testing.T) {
+	process, _ := startHelper(t, "sleep")
+	if !process.Running() || process.ExitCode() != -1 {
+		t.Fatalf("Running() = %v, ExitCode() = %d; want true, -1", process.Running(), process.ExitCode())
+	}
+
+	err := process.Kill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitExited(t, process)
+	if err := process.Wait(); err == nil {
+		t.Error("Wait() = nil for a killed game")
+	}
+	// Windows reports an exit code of 1 for a killed process.
+	if runtime.GOOS != "windows" && process.ExitCode() != -1 {
+		t.Errorf("ExitCode() = %d for a killed game, want -1", process.ExitCode())
+	}
+}
+
+func TestOutputBufferDropsOldest(t *testing.T) {
+	buf := newOutputBuffer(8)
+	buf.Write([]byte("01234"))
+	buf.Write([]byte("56789"))
+
+	p := make([]byte, 4)
+	n, err := buf.Read(p)
+	if err != nil || string(p[:n]) != "2345" {
+		t.Fatalf("Read() = %q, %v; want 2345", p[:n], err)
+	}
+
+	buf.Write([]byte("ab"))
+	buf.Close()
+	rest, err := io.ReadAll(buf)
+	if err != nil || string(rest) != "6789ab" {
+		t.Errorf("ReadAll() = %q, %v; want 6789ab", rest, err)
+	}
+}
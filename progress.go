@@ -0,0 +1,152 @@
+package piston
+
+import "errors"
+
+type Phase string
+
+const (
+	PhaseManifest   Phase = "manifest"
+	PhaseClientJar  Phase = "client"
+	PhaseLibraries  Phase = "libraries"
+	PhaseNatives    Phase = "natives"
+	PhaseAssets     Phase = "assets"
+	PhaseJDK        Phase = "jdk"
+	PhaseProcessors Phase = "processors"
+)
+
+type ProgressKind int
+
+const (
+	PhaseStarted ProgressKind = iota
+	PhaseFinished
+	FileProgress
+	FileFinished
+	ProgressError
+)
+
+// ProgressEvent describes a single step of an install.
+//
+// For PhaseStarted, Files and Total hold the number of files and the number of
+// bytes expected in the phase (Total is 0 when sizes are unknown). For
+// FileProgress, Bytes is the amount written since the previous event for the
+// same file, Written the running total and Total the expected file size.
+// Bytes is negative when a failed attempt is rolled back before a retry.
+// Files that are already present and valid produce a single FileProgress
+// covering their whole size. Err is set on ProgressError and on a
+// PhaseFinished that ended in failure.
+type ProgressEvent struct {
+	Kind    ProgressKind
+	Phase   Phase
+	URL     string
+	Path    string
+	Files   int
+	Bytes   int64
+	Written int64
+	Total   int64
+	Err     error
+}
+
+// ProgressReporter receives install progress. Downloads run in parallel, so
+// Report must be safe for concurrent use.
+type ProgressReporter interface {
+	Report(event ProgressEvent)
+}
+
+// ProgressFunc adapts a plain function to ProgressReporter.
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) Report(event ProgressEvent) {
+	f(event)
+}
+
+// ProgressChan delivers events to a channel. Sends block, so the channel must
+// be drained for the install to make progress.
+type ProgressChan chan<- ProgressEvent
+
+func (c ProgressChan) Report(event ProgressEvent) {
+	c <- event
+}
+
+func report(r ProgressReporter, event ProgressEvent) {
+	if r != nil {
+		r.Report(event)
+	}
+}
+
+func startPhase(r ProgressReporter, phase Phase, files int, total int64) {
+	report(r, ProgressEvent{Kind: PhaseStarted, Phase: phase, Files: files, Total: total})
+}
+
+func finishPhase(r ProgressReporter, phase Phase, err error) error {
+	if err != nil {
+		event := ProgressEvent{Kind: ProgressError, Phase: phase, Err: err}
+		var downloadErr *DownloadError
+		if errors.As(err, &downloadErr) {
+			event.URL = downloadErr.URL
+		}
+		report(r, event)
+	}
+	report(r, ProgressEvent{Kind: PhaseFinished, Phase: phase, Err: err})
+	return err
+}
+
+type progressWriter struct {
+	reporter ProgressReporter
+	event    ProgressEvent
+}
+
+func newProgressWriter(r ProgressReporter, phase Phase, url string, path string, total int64) *progressWriter {
+	if r == nil {
+		return nil
+	}
+
+	return &progressWriter{
+		reporter: r,
+		event: ProgressEvent{
+			Kind:  FileProgress,
+			Phase: phase,
+			URL:   url,
+			Path:  path,
+			Total: total,
+		},
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.add(int64(len(p)))
+	return len(p), nil
+}
+
+func (w *progressWriter) add(n int64) {
+	if n == 0 {
+		return
+	}
+	w.event.Bytes = n
+	w.event.Written += n
+	w.reporter.Report(w.event)
+}
+
+// rewind takes back the bytes of a failed attempt so that a retry does not
+// count them twice.
+func (w *progressWriter) rewind() {
+	if w.event.Written == 0 {
+		return
+	}
+	w.event.Bytes = -w.event.Written
+	w.event.Written = 0
+	w.reporter.Report(w.event)
+}
+
+func (w *progressWriter) skip() {
+	w.event.Bytes = w.event.Total
+	w.event.Written = w.event.Total
+	w.reporter.Report(w.event)
+	w.finish()
+}
+
+func (w *progressWriter) finish() {
+	event := w.event
+	event.Kind = FileFinished
+	event.Bytes = 0
+	w.reporter.Report(event)
+}
@@ -0,0 +1,155 @@
+package piston
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// progressLog collects the events of an install.
+type progressLog struct {
+	mu     sync.Mutex
+	events []ProgressEvent
+}
+
+func (l *progressLog) Report(event ProgressEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *progressLog) get() []ProgressEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ProgressEvent(nil), l.events...)
+}
+
+func runPhase(s *scheduler, phase Phase, tasks []downloadTask) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	startPhase(s.progress, phase, len(tasks), tasksSize(tasks))
+	return finishPhase(s.progress, phase, s.run(ctx, phase, tasks))
+}
+
+func TestProgressEvents(t *testing.T) {
+	body := testBody()
+	var flakyRequests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first response for the flaky file is corrupt and retried.
+		if r.URL.Path == "/flaky" && flakyRequests.Add(1) == 1 {
+			w.Write(bytes.Repeat([]byte{0}, len(body)))
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var log progressLog
+	s := newScheduler(PistonLauncher{
+		BasePath: t.TempDir(),
+		Progress: &log,
+		Retry:    RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	task := func(name string) downloadTask {
+		return downloadTask{URL: srv.URL + "/" + name, Dest: filepath.Join(s.cacheDir, name), SHA1: sha1Hex(body), Size: int64(len(body))}
+	}
+	tasks := []downloadTask{task("present"), task("plain"), task("flaky")}
+	err := os.MkdirAll(s.cacheDir, 0755)
+	if err == nil {
+		err = os.WriteFile(tasks[0].Dest, body, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runPhase(s, PhaseLibraries, tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := log.get()
+	first, last := events[0], events[len(events)-1]
+	if first.Kind != PhaseStarted || first.Files != 3 || first.Total != 3*int64(len(body)) {
+		t.Errorf("first event = %+v, want PhaseStarted for 3 files and %d bytes", first, 3*len(body))
+	}
+	if last.Kind != PhaseFinished || last.Err != nil {
+		t.Errorf("last event = %+v, want a successful PhaseFinished", last)
+	}
+
+	var total int64
+	bytesOf := map[string]int64{}
+	progressEvents := map[string]int{}
+	finished := map[string]int{}
+	rewound := map[string]bool{}
+	for _, event := range events {
+		if event.Phase != PhaseLibraries {
+			t.Errorf("event for phase %s", event.Phase)
+		}
+		name := filepath.Base(event.Path)
+		switch event.Kind {
+		case FileProgress:
+			total += event.Bytes
+			bytesOf[name] += event.Bytes
+			progressEvents[name]++
+			if event.Bytes < 0 {
+				rewound[name] = true
+			}
+			if event.Written != bytesOf[name] {
+				t.Errorf("%s: Written = %d after %d bytes", name, event.Written, bytesOf[name])
+			}
+		case FileFinished:
+			finished[name]++
+			if bytesOf[name] != int64(len(body)) {
+				t.Errorf("%s finished after %d bytes, want %d", name, bytesOf[name], len(body))
+			}
+		case ProgressError:
+			t.Errorf("unexpected error event %+v", event)
+		}
+	}
+
+	if total != first.Total {
+		t.Errorf("file events add up to %d bytes, want %d", total, first.Total)
+	}
+	for _, name := range []string{"present", "plain", "flaky"} {
+		if finished[name] != 1 {
+			t.Errorf("%s finished %d times", name, finished[name])
+		}
+	}
+	if progressEvents["present"] != 1 {
+		t.Errorf("the present file produced %d progress events, want 1", progressEvents["present"])
+	}
+	if !rewound["flaky"] || rewound["plain"] {
+		t.Errorf("rewound %v, want only the flaky file", rewound)
+	}
+}
+
+func TestProgressEventsOnFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	var log progressLog
+	s := newScheduler(PistonLauncher{BasePath: t.TempDir(), Progress: &log, Retry: RetryPolicy{MaxAttempts: 1}})
+	url := srv.URL + "/missing"
+	err := runPhase(s, PhaseAssets, []downloadTask{{URL: url, Dest: filepath.Join(s.cacheDir, "missing")}})
+	if err == nil {
+		t.Fatal("downloaded a missing file")
+	}
+
+	events := log.get()
+	if len(events) != 3 {
+		t.Fatalf("events = %+v, want PhaseStarted, ProgressError and PhaseFinished", events)
+	}
+	if events[1].Kind != ProgressError || events[1].URL != url || events[1].Err != err {
+		t.Errorf("error event = %+v", events[1])
+	}
+	if events[2].Kind != PhaseFinished || events[2].Err != err {
+		t.Errorf("last event = %+v, want a failed PhaseFinished", events[2])
+	}
+}
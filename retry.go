@@ -0,0 +1,108 @@
+package piston
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how failed downloads are retried. A request is retried
+// when the connection fails, times out or is cut short, when the server
+// answers 408, 429 or any 5xx status, and when the downloaded file fails its
+// size or checksum verification. Delays grow exponentially from
+// BaseDelay up to MaxDelay and are randomised by ±Jitter (a fraction of the
+// delay). The zero value means DefaultRetryPolicy, and a zero MaxAttempts,
+// BaseDelay or MaxDelay takes its value from it; set MaxAttempts to 1 to
+// disable retries.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    15 * time.Second,
+	Jitter:      0.2,
+}
+
+// orDefault fills the zero fields of p from DefaultRetryPolicy, so that e.g.
+// RetryPolicy{MaxAttempts: 5} still backs off between attempts.
+func (p RetryPolicy) orDefault() RetryPolicy {
+	if p == (RetryPolicy{}) {
+		return DefaultRetryPolicy
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = max(DefaultRetryPolicy.MaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.MaxDelay
+	// Checking against MaxDelay before shifting keeps BaseDelay << shift from
+	// overflowing.
+	if shift := attempt - 1; shift < 62 && p.BaseDelay <= p.MaxDelay>>shift {
+		d = p.BaseDelay << shift
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration(float64(d) * p.Jitter * (2*rand.Float64() - 1))
+	}
+	return max(d, 0)
+}
+
+func isRetryable(err error) bool {
+	var checksumErr *ChecksumError
+	var sizeErr *SizeError
+	if errors.As(err, &checksumErr) || errors.As(err, &sizeErr) {
+		return true
+	}
+
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) || errors.Is(err, ErrOffline) {
+		return false
+	}
+
+	if downloadErr.Cause != nil {
+		return true
+	}
+
+	switch downloadErr.Status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return downloadErr.Status >= 500
+}
+
+// retry calls fn until it succeeds, returns an error that is not worth
+// retrying, the policy runs out of attempts or ctx is done.
+func retry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	policy = policy.orDefault()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || ctx.Err() != nil || !isRetryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(policy.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package piston
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{RetryPolicy{}, 1, 500 * time.Millisecond},
+		{RetryPolicy{MaxAttempts: 5}, 1, 500 * time.Millisecond},
+		{RetryPolicy{MaxAttempts: 5}, 3, 2 * time.Second},
+		{RetryPolicy{MaxAttempts: 5}, 10, 15 * time.Second},
+		{RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second}, 2, 2 * time.Second},
+		{RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second}, 3, 3 * time.Second},
+		// MaxDelay defaults to at least BaseDelay.
+		{RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute}, 3, time.Minute},
+		// Shifts that would overflow are capped.
+		{RetryPolicy{MaxAttempts: 100}, 40, 15 * time.Second},
+		{RetryPolicy{MaxAttempts: 100}, 64, 15 * time.Second},
+		{RetryPolicy{MaxAttempts: 100}, 100, 15 * time.Second},
+	}
+
+	for _, test := range tests {
+		got := test.policy.orDefault().delay(test.attempt)
+		if test.policy.Jitter == 0 && test.policy.MaxAttempts != 0 {
+			if got != test.want {
+				t.Errorf("%+v: delay(%d) = %v, want %v", test.policy, test.attempt, got, test.want)
+			}
+			continue
+		}
+		// The default policy jitters by 20%.
+		if got < test.want*8/10 || got > test.want*12/10 {
+			t.Errorf("%+v: delay(%d) = %v, want %v ± 20%%", test.policy, test.attempt, got, test.want)
+		}
+	}
+}
+
+func TestRetryPolicyOrDefault(t *testing.T) {
+	if got := (RetryPolicy{}).orDefault(); got != DefaultRetryPolicy {
+		t.Errorf("zero policy = %+v, want %+v", got, DefaultRetryPolicy)
+	}
+	policy := RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+	if got := policy.orDefault(); got != policy {
+		t.Errorf("complete policy = %+v, want it unchanged", got)
+	}
+}
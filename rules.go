@@ -0,0 +1,176 @@
+package piston
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Environment is what the rules in version JSONs are evaluated against. The
+// zero value of each field only satisfies rules that do not mention it.
+type Environment struct {
+	// OS uses Mojang's names: "windows", "osx" or "linux".
+	OS string
+	// Arch uses the architecture names of Mojang's natives classifiers and
+	// arch rules, "x86", "x86_64" or "arm64", rather than Java's os.arch
+	// values such as "amd64" or "aarch64".
+	Arch string
+	// OSVersion is matched against the regular expressions of os.version
+	// rules, e.g. "10.0" on Windows 10 and 11 or "14.2" on macOS.
+	OSVersion string
+	// Features are the launch features that are enabled, e.g.
+	// has_custom_resolution.
+	Features map[string]bool
+}
+
+// mojangOS maps a GOOS value onto the OS name used in version JSONs.
+func mojangOS(goos string) string {
+	switch goos {
+	case "darwin":
+		return "osx"
+	default:
+		return goos
+	}
+}
+
+// mojangArch maps a GOARCH value onto the architecture name Environment.Arch
+// uses.
+func mojangArch(goarch string) string {
+	switch goarch {
+	case "386":
+		return "x86"
+	case "amd64":
+		return "x86_64"
+	default:
+		return goarch
+	}
+}
+
+var hostOSVersion = sync.OnceValue(func() string {
+	switch runtime.GOOS {
+	case "windows":
+		// "Microsoft Windows [Version 10.0.22631.3007]" becomes "10.0", the
+		// form Java reports as os.version.
+		out, err := exec.Command("cmd", "/c", "ver").Output()
+		if err != nil {
+			return ""
+		}
+		version := regexp.MustCompile(`\d+\.\d+`).FindString(string(out))
+		return version
+	case "darwin":
+		out, err := exec.Command("sw_vers", "-productVersion").Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	default:
+		data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+})
+
+// HostEnvironment describes the machine the launcher runs on, without any
+// features enabled.
+func HostEnvironment() Environment {
+	return Environment{
+		OS:        mojangOS(runtime.GOOS),
+		Arch:      mojangArch(runtime.GOARCH),
+		OSVersion: hostOSVersion(),
+	}
+}
+
+// withFeatures returns a copy of env with features enabled on top of its own.
+func (env Environment) withFeatures(features map[string]bool) Environment {
+	merged := make(map[string]bool, len(env.Features)+len(features))
+	for feature, enabled := range env.Features {
+		merged[feature] = enabled
+	}
+	for feature, enabled := range features {
+		merged[feature] = merged[feature] || enabled
+	}
+	env.Features = merged
+	return env
+}
+
+// osVersionPatterns holds the compiled os.version patterns of rules, which
+// are evaluated for every library and argument of every launch. Invalid
+// patterns are kept as nil.
+var osVersionPatterns sync.Map
+
+func osVersionPattern(expr string) *regexp.Regexp {
+	if pattern, ok := osVersionPatterns.Load(expr); ok {
+		return pattern.(*regexp.Regexp)
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		pattern = nil
+	}
+	osVersionPatterns.Store(expr, pattern)
+	return pattern
+}
+
+// matches reports whether rule applies in env.
+func (env Environment) matches(rule Rule) bool {
+	if rule.OS.Name != "" && rule.OS.Name != env.OS {
+		return false
+	}
+	if rule.OS.Arch != "" && rule.OS.Arch != env.Arch {
+		return false
+	}
+	if rule.OS.Version != "" {
+		pattern := osVersionPattern(rule.OS.Version)
+		if pattern == nil || !pattern.MatchString(env.OSVersion) {
+			return false
+		}
+	}
+	for feature, want := range rule.Features {
+		if env.Features[feature] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Allows evaluates rules the way the official launcher does: no rules allow
+// everything, otherwise the last rule that applies decides, and nothing is
+// allowed when none applies.
+func (env Environment) Allows(rules []Rule) bool {
+	if len(rules) == 0 {
+		return true
+	}
+
+	allowed := false
+	for _, rule := range rules {
+		if env.matches(rule) {
+			allowed = rule.Action == "allow"
+		}
+	}
+	return allowed
+}
+
+// nativesClassifier returns the classifier holding lib's natives for env,
+// or "" when it has none. "${arch}" in the classifier becomes 32 or 64.
+func (env Environment) nativesClassifier(lib Library) string {
+	var key string
+	switch env.OS {
+	case "windows":
+		key = lib.Natives.Windows
+	case "linux":
+		key = lib.Natives.Linux
+	case "osx":
+		key = lib.Natives.Osx
+	}
+
+	bits := "64"
+	if env.Arch == "x86" {
+		bits = "32"
+	}
+	return strings.ReplaceAll(key, "${arch}", bits)
+}
@@ -0,0 +1,157 @@
+package piston
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func parseRules(t *testing.T, data string) []Rule {
+	t.Helper()
+	var rules []Rule
+	err := json.Unmarshal([]byte(data), &rules)
+	if err != nil {
+		t.Fatalf("invalid rules %s: %v", data, err)
+	}
+	return rules
+}
+
+func TestEnvironmentAllows(t *testing.T) {
+	windows10 := Environment{OS: "windows", Arch: "x86_64", OSVersion: "10.0"}
+	windows32 := Environment{OS: "windows", Arch: "x86", OSVersion: "6.1"}
+	mac := Environment{OS: mojangOS("darwin"), Arch: mojangArch("arm64"), OSVersion: "14.2"}
+	linux := Environment{OS: mojangOS("linux"), Arch: mojangArch("amd64"), OSVersion: "6.8.0"}
+
+	const (
+		allowOSX         = `[{"action":"allow","os":{"name":"osx"}}]`
+		disallowOSX      = `[{"action":"allow"},{"action":"disallow","os":{"name":"osx"}}]`
+		allowX86         = `[{"action":"allow","os":{"arch":"x86"}}]`
+		allowWindows10   = `[{"action":"allow","os":{"name":"windows","version":"^10\\."}}]`
+		disallowWindows7 = `[{"action":"allow"},{"action":"disallow","os":{"name":"windows","version":"^6\\.1"}}]`
+		allowDemo        = `[{"action":"allow","features":{"is_demo_user":true}}]`
+		allowNoDemo      = `[{"action":"allow","features":{"is_demo_user":false}}]`
+		lastWins         = `[{"action":"disallow","os":{"name":"linux"}},{"action":"allow","os":{"arch":"x86_64"}}]`
+		lastWinsReversed = `[{"action":"allow","os":{"arch":"x86_64"}},{"action":"disallow","os":{"name":"linux"}}]`
+		invalidVersion   = `[{"action":"allow","os":{"version":"("}}]`
+	)
+
+	tests := []struct {
+		env   Environment
+		rules string
+		want  bool
+	}{
+		{linux, `[]`, true},
+		{linux, `[{"action":"disallow"}]`, false},
+
+		{mac, allowOSX, true},
+		{linux, allowOSX, false},
+		{mac, disallowOSX, false},
+		{windows10, disallowOSX, true},
+
+		{windows32, allowX86, true},
+		{windows10, allowX86, false},
+		{mac, allowX86, false},
+
+		{windows10, allowWindows10, true},
+		{windows32, allowWindows10, false},
+		{windows32, disallowWindows7, false},
+		{windows10, disallowWindows7, true},
+		{Environment{OS: "windows"}, allowWindows10, false},
+		{linux, invalidVersion, false},
+
+		{linux, allowDemo, false},
+		{linux.withFeatures(map[string]bool{"is_demo_user": true}), allowDemo, true},
+		{linux.withFeatures(map[string]bool{"has_custom_resolution": true}), allowDemo, false},
+		{linux, allowNoDemo, true},
+		{linux.withFeatures(map[string]bool{"is_demo_user": true}), allowNoDemo, false},
+
+		{linux, lastWins, true},
+		{linux, lastWinsReversed, false},
+		{windows32, lastWins, false},
+		{windows10, lastWinsReversed, true},
+	}
+
+	for _, test := range tests {
+		name := fmt.Sprintf("%s/%s/%s %s", test.env.OS, test.env.Arch, test.env.OSVersion, test.rules)
+		t.Run(name, func(t *testing.T) {
+			got := test.env.Allows(parseRules(t, test.rules))
+			if got != test.want {
+				t.Errorf("Allows = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentWithFeatures(t *testing.T) {
+	env := Environment{Features: map[string]bool{"is_demo_user": true}}
+	merged := env.withFeatures(map[string]bool{"has_custom_resolution": true, "is_demo_user": false})
+
+	if !merged.Features["is_demo_user"] || !merged.Features["has_custom_resolution"] {
+		t.Errorf("merged features = %v", merged.Features)
+	}
+	if env.Features["has_custom_resolution"] {
+		t.Error("withFeatures modified the original environment")
+	}
+}
+
+func TestNativesClassifier(t *testing.T) {
+	lib := Library{Natives: NativeMapping{Windows: "natives-windows-${arch}", Osx: "natives-osx"}}
+
+	tests := []struct {
+		env  Environment
+		want string
+	}{
+		{Environment{OS: "windows", Arch: "x86"}, "natives-windows-32"},
+		{Environment{OS: "windows", Arch: "x86_64"}, "natives-windows-64"},
+		{Environment{OS: "osx", Arch: "arm64"}, "natives-osx"},
+		{Environment{OS: "linux", Arch: "x86_64"}, ""},
+	}
+	for _, test := range tests {
+		got := test.env.nativesClassifier(lib)
+		if got != test.want {
+			t.Errorf("nativesClassifier in %s/%s = %q, want %q", test.env.OS, test.env.Arch, got, test.want)
+		}
+	}
+}
+
+func TestNativeArtifacts(t *testing.T) {
+	var libs []Library
+	err := json.Unmarshal([]byte(`[
+		{"name": "org.lwjgl:lwjgl:3.3.3", "downloads": {"artifact": {"url": "lwjgl.jar"}}},
+		{"name": "org.lwjgl:lwjgl:3.3.3:natives-linux", "downloads": {"artifact": {"url": "linux.jar"}}, "rules": [{"action": "allow", "os": {"name": "linux"}}]},
+		{"name": "org.lwjgl:lwjgl:3.3.3:natives-linux-arm64", "downloads": {"artifact": {"url": "linux-arm64.jar"}}, "rules": [{"action": "allow", "os": {"name": "linux"}}]},
+		{"name": "org.lwjgl:lwjgl:3.3.3:natives-windows", "downloads": {"artifact": {"url": "windows.jar"}}, "rules": [{"action": "allow", "os": {"name": "windows"}}]},
+		{"name": "org.lwjgl:lwjgl:3.3.3:natives-windows-x86", "downloads": {"artifact": {"url": "windows-x86.jar"}}, "rules": [{"action": "allow", "os": {"name": "windows"}}]},
+		{"name": "org.lwjgl:lwjgl:3.3.3:natives-windows-arm64", "downloads": {"artifact": {"url": "windows-arm64.jar"}}, "rules": [{"action": "allow", "os": {"name": "windows"}}]},
+		{"name": "org.lwjgl:lwjgl-stb:3.3.3:natives-linux", "downloads": {"artifact": {"url": "stb-linux.jar"}}, "rules": [{"action": "allow", "os": {"name": "linux"}}]},
+		{"name": "org.lwjgl:lwjgl-tinyfd:3.3.3:natives-macos-arm64", "downloads": {"artifact": {"url": "tinyfd-macos-arm64.jar"}}, "rules": [{"action": "allow", "os": {"name": "osx"}}]}
+	]`), &libs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		env  Environment
+		want []string
+	}{
+		{Environment{OS: "linux", Arch: "x86_64"}, []string{"linux.jar", "stb-linux.jar"}},
+		// lwjgl-stb has no arm64 variant, so the x86_64 one is used.
+		{Environment{OS: "linux", Arch: "arm64"}, []string{"linux-arm64.jar", "stb-linux.jar"}},
+		{Environment{OS: "windows", Arch: "x86"}, []string{"windows-x86.jar"}},
+		{Environment{OS: "windows", Arch: "arm64"}, []string{"windows-arm64.jar"}},
+		// The only variant is for arm64 and there is no x86_64 one to fall
+		// back on.
+		{Environment{OS: "osx", Arch: "x86_64"}, nil},
+		{Environment{OS: "osx", Arch: "arm64"}, []string{"tinyfd-macos-arm64.jar"}},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, lib := range nativeArtifacts(libs, test.env) {
+			got = append(got, lib.Downloads.Artifact.URL)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("nativeArtifacts in %s/%s = %v, want %v", test.env.OS, test.env.Arch, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package piston
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConcurrency     = 16
+	DefaultMaxConnsPerHost = 8
+	DefaultUserAgent       = "piston.go"
+)
+
+type downloadTask struct {
+	URL       string
+	Dest      string
+	SHA1      string
+	SHA256    string
+	Size      int64
+	Resumable bool
+}
+
+// valid reports whether task.Dest already holds the file the task describes,
+// checking every hash it has.
+func (task downloadTask) valid() bool {
+	if !fileValid(task.Dest, task.SHA1, task.Size) {
+		return false
+	}
+	if task.SHA256 == "" {
+		return true
+	}
+	sum, err := fileSum(task.Dest, sha256.New)
+	return err == nil && strings.EqualFold(sum, task.SHA256)
+}
+
+func tasksSize(tasks []downloadTask) int64 {
+	var total int64
+	for _, task := range tasks {
+		total += task.Size
+	}
+	return total
+}
+
+// downloadSlots counts the requests in flight per host for every launcher
+// downloading into the same BasePath, so concurrent installs into it share the
+// hosts' connections. Each request waits until fewer than its own launcher's
+// MaxConnsPerHost are in flight to its host, so launchers with different
+// limits each keep theirs. Downloads into the same destination are also
+// serialised, as they share its temporary file. An entry only lives while
+// downloads run into its BasePath.
+type downloadSlots struct {
+	basePath string
+	refs     int // guarded by sharedSlotsMu
+
+	mu    sync.Mutex
+	hosts map[string]int
+	freed chan struct{}
+	dests map[string]*destLock
+}
+
+type destLock struct {
+	held chan struct{}
+	refs int
+}
+
+var (
+	sharedSlotsMu sync.Mutex
+	sharedSlots   = map[string]*downloadSlots{}
+)
+
+// slotsFor returns the slots shared by downloads into basePath. Callers must
+// call done once their downloads finished.
+func slotsFor(basePath string) *downloadSlots {
+	if abs, err := filepath.Abs(basePath); err == nil {
+		basePath = abs
+	}
+
+	sharedSlotsMu.Lock()
+	defer sharedSlotsMu.Unlock()
+
+	slots, ok := sharedSlots[basePath]
+	if !ok {
+		slots = &downloadSlots{
+			basePath: basePath,
+			hosts:    map[string]int{},
+			freed:    make(chan struct{}),
+			dests:    map[string]*destLock{},
+		}
+		sharedSlots[basePath] = slots
+	}
+	slots.refs++
+	return slots
+}
+
+func (slots *downloadSlots) done() {
+	sharedSlotsMu.Lock()
+	defer sharedSlotsMu.Unlock()
+
+	slots.refs--
+	if slots.refs == 0 {
+		delete(sharedSlots, slots.basePath)
+	}
+}
+
+// acquire waits until fewer than limit requests are in flight to host and
+// returns the function releasing the slot it took.
+func (slots *downloadSlots) acquire(ctx context.Context, host string, limit int) (func(), error) {
+	for {
+		slots.mu.Lock()
+		if slots.hosts[host] < limit {
+			slots.hosts[host]++
+			slots.mu.Unlock()
+			return func() { slots.release(host) }, nil
+		}
+		freed := slots.freed
+		slots.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (slots *downloadSlots) release(host string) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	slots.hosts[host]--
+	if slots.hosts[host] == 0 {
+		delete(slots.hosts, host)
+	}
+	close(slots.freed)
+	slots.freed = make(chan struct{})
+}
+
+// lock waits until no other download into BasePath writes dest and returns the
+// function unlocking it.
+func (slots *downloadSlots) lock(ctx context.Context, dest string) (func(), error) {
+	if abs, err := filepath.Abs(dest); err == nil {
+		dest = abs
+	}
+
+	slots.mu.Lock()
+	l, ok := slots.dests[dest]
+	if !ok {
+		l = &destLock{held: make(chan struct{}, 1)}
+		slots.dests[dest] = l
+	}
+	l.refs++
+	slots.mu.Unlock()
+
+	unref := func() {
+		slots.mu.Lock()
+		defer slots.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(slots.dests, dest)
+		}
+	}
+
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		unref()
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-l.held
+		unref()
+	}, nil
+}
+
+// scheduler runs download tasks on a pool of workers, holding one of the
+// download slots of its BasePath for each request. Every request the launcher
+// makes goes through it, so it also carries the HTTP settings.
+type scheduler struct {
+	basePath  string
+	workers   int
+	perHost   int
+	progress  ProgressReporter
+	retry     RetryPolicy
+	client    *http.Client
+	endpoints Endpoints
+	userAgent string
+	logger    *log.Logger
+
+	cacheDir    string
+	manifestTTL time.Duration
+	offline     bool
+	env         Environment
+}
+
+func newScheduler(launcher PistonLauncher) *scheduler {
+	s := &scheduler{
+		basePath:  launcher.BasePath,
+		workers:   launcher.Concurrency,
+		perHost:   launcher.MaxConnsPerHost,
+		progress:  launcher.Progress,
+		retry:     launcher.Retry,
+		client:    launcher.HTTPClient,
+		endpoints: launcher.Endpoints,
+		userAgent: launcher.UserAgent,
+		logger:    launcher.logger(),
+
+		cacheDir:    filepath.Join(launcher.BasePath, "cache"),
+		manifestTTL: launcher.ManifestTTL,
+		offline:     launcher.Offline,
+		env:         launcher.environment(),
+	}
+
+	if s.workers <= 0 {
+		s.workers = DefaultConcurrency
+	}
+	if s.perHost <= 0 {
+		s.perHost = DefaultMaxConnsPerHost
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.userAgent == "" {
+		s.userAgent = DefaultUserAgent
+	}
+	if s.manifestTTL == 0 {
+		s.manifestTTL = DefaultManifestTTL
+	}
+
+	return s
+}
+
+// host returns the host rawURL is requested from once rewritten through the
+// endpoints.
+func (s *scheduler) host(rawURL string) string {
+	if u, err := url.Parse(s.endpoints.resolve(rawURL)); err == nil {
+		return u.Host
+	}
+	return rawURL
+}
+
+// run downloads every task and waits for all of them to finish, reporting file
+// progress under phase. Tasks whose destination already holds a file with the
+// expected hashes and size are skipped, and duplicate destinations are fetched once. The
+// first failure cancels the remaining tasks and is returned.
+func (s *scheduler) run(ctx context.Context, phase Phase, tasks []downloadTask) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	slots := slotsFor(s.basePath)
+	defer slots.done()
+
+	var (
+		firstErr error
+		errOnce  sync.Once
+		wg       sync.WaitGroup
+	)
+
+	queue := make(chan downloadTask)
+	for i := 0; i < min(s.workers, len(tasks)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				err := s.fetch(ctx, slots, phase, task)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	seen := map[string]bool{}
+enqueue:
+	for _, task := range tasks {
+		if seen[task.Dest] {
+			continue
+		}
+		seen[task.Dest] = true
+
+		select {
+		case queue <- task:
+		case <-ctx.Done():
+			break enqueue
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (s *scheduler) fetch(ctx context.Context, slots *downloadSlots, phase Phase, task downloadTask) error {
+	progress := newProgressWriter(s.progress, phase, task.URL, task.Dest, task.Size)
+
+	// Another install may be fetching the same file; once it is done, the
+	// file is valid and skipped here.
+	unlock, err := slots.lock(ctx, task.Dest)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if task.valid() {
+		if progress != nil {
+			progress.skip()
+		}
+		return nil
+	}
+
+	// The slot is only held while a request is made, not while waiting to
+	// retry it.
+	host := s.host(task.URL)
+	return retry(ctx, s.retry, func() error {
+		release, err := slots.acquire(ctx, host, s.perHost)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return s.downloadFile(ctx, task, progress)
+	})
+}
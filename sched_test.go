@@ -0,0 +1,167 @@
+package piston
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulersShareHostLimit(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	basePath := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		var tasks []downloadTask
+		for j := 0; j < 4; j++ {
+			name := fmt.Sprintf("/%d-%d", i, j)
+			tasks = append(tasks, downloadTask{URL: srv.URL + name, Dest: filepath.Join(basePath, name)})
+		}
+
+		sched := PistonLauncher{BasePath: basePath, MaxConnsPerHost: 2}.scheduler()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sched.run(ctx, PhaseLibraries, tasks)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if peak.Load() > 2 {
+		t.Errorf("%d requests were in flight against one host, want at most 2", peak.Load())
+	}
+}
+
+func TestSchedulersKeepOwnHostLimit(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	basePath := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tasks := func(prefix string, n int) []downloadTask {
+		var tasks []downloadTask
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("/%s-%d", prefix, i)
+			tasks = append(tasks, downloadTask{URL: srv.URL + name, Dest: filepath.Join(basePath, name)})
+		}
+		return tasks
+	}
+
+	slow := make(chan error, 1)
+	go func() {
+		sched := PistonLauncher{BasePath: basePath, MaxConnsPerHost: 1}.scheduler()
+		slow <- sched.run(ctx, PhaseLibraries, tasks("slow", 4))
+	}()
+	for inFlight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A launcher rebuilt with a higher limit gets it while the first one is
+	// still downloading.
+	sched := PistonLauncher{BasePath: basePath, MaxConnsPerHost: 3}.scheduler()
+	err := sched.run(ctx, PhaseLibraries, tasks("fast", 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+
+	if peak.Load() != 3 {
+		t.Errorf("%d requests were in flight against one host, want 3", peak.Load())
+	}
+
+	sharedSlotsMu.Lock()
+	defer sharedSlotsMu.Unlock()
+	if len(sharedSlots) != 0 {
+		t.Errorf("%d download slot entries outlived their downloads", len(sharedSlots))
+	}
+}
+
+func TestRetryReleasesHostSlot(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" && requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	launcher := PistonLauncher{
+		BasePath:        t.TempDir(),
+		MaxConnsPerHost: 1,
+		Retry:           RetryPolicy{MaxAttempts: 2, BaseDelay: time.Second},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := launcher.scheduler()
+	flaky := make(chan error, 1)
+	go func() {
+		task := downloadTask{URL: srv.URL + "/flaky", Dest: filepath.Join(launcher.BasePath, "flaky")}
+		flaky <- s.run(ctx, PhaseLibraries, []downloadTask{task})
+	}()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The flaky download is waiting to retry, which must not keep the only
+	// slot for the host.
+	start := time.Now()
+	task := downloadTask{URL: srv.URL + "/other", Dest: filepath.Join(launcher.BasePath, "other")}
+	err := s.run(ctx, PhaseLibraries, []downloadTask{task})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("download waited %v for the slot held through a retry delay", elapsed)
+	}
+
+	if err := <-flaky; err != nil {
+		t.Fatal(err)
+	}
+}
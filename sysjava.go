@@ -0,0 +1,282 @@
+package piston
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var javaProbeTimeout = 15 * time.Second
+
+// JavaInstallation describes a Java runtime found on the system, as reported
+// by the runtime itself.
+type JavaInstallation struct {
+	Path    string
+	Home    string
+	Version string
+	Major   uint16
+	Vendor  string
+	Arch    string
+}
+
+// javaSearchRoots returns directories whose children are typically Java
+// homes on the current platform.
+func javaSearchRoots() []string {
+	home, _ := os.UserHomeDir()
+
+	roots := []string{
+		filepath.Join(home, ".sdkman", "candidates", "java"),
+		filepath.Join(home, ".asdf", "installs", "java"),
+		filepath.Join(home, ".jabba", "jdk"),
+		filepath.Join(home, ".jdks"),
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "ProgramW6432"} {
+			base := os.Getenv(env)
+			if base == "" {
+				continue
+			}
+			for _, vendor := range []string{"Java", "Eclipse Adoptium", "Eclipse Foundation", "AdoptOpenJDK", "Zulu", "Microsoft", "BellSoft", "Amazon Corretto", "GraalVM"} {
+				roots = append(roots, filepath.Join(base, vendor))
+			}
+		}
+	case "darwin":
+		roots = append(roots,
+			"/Library/Java/JavaVirtualMachines",
+			filepath.Join(home, "Library", "Java", "JavaVirtualMachines"),
+			"/opt/homebrew/opt",
+		)
+	default:
+		roots = append(roots, "/usr/lib/jvm", "/usr/lib64/jvm", "/usr/java", "/opt/java", "/opt/jdk", "/opt")
+	}
+
+	return roots
+}
+
+// javaCandidates lists java executables worth probing, without duplicates.
+func javaCandidates() []string {
+	var dirs []string
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+		dirs = append(dirs, javaHome)
+	}
+
+	for _, root := range javaSearchRoots() {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			dirs = append(dirs, filepath.Join(root, entry.Name()))
+		}
+	}
+
+	var candidates []string
+	for _, dir := range dirs {
+		if path := findJavaExecutable(dir); path != "" {
+			candidates = append(candidates, path)
+		}
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		path := filepath.Join(dir, javaExecutableName())
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			candidates = append(candidates, path)
+		}
+	}
+
+	seen := map[string]bool{}
+	var unique []string
+	for _, path := range candidates {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil || seen[real] {
+			continue
+		}
+		seen[real] = true
+		unique = append(unique, path)
+	}
+	return unique
+}
+
+// DiscoverJava looks for Java runtimes installed outside the launcher: in
+// JAVA_HOME, on PATH, in the usual per-platform install directories and in
+// SDKMAN, asdf, jabba and IntelliJ download folders. Every candidate is run
+// to read its real version, vendor and architecture; those that fail to run
+// are skipped.
+func DiscoverJava(ctx context.Context) ([]JavaInstallation, error) {
+	var installs []JavaInstallation
+	for _, path := range javaCandidates() {
+		if err := ctx.Err(); err != nil {
+			return installs, err
+		}
+
+		install, err := ProbeJava(ctx, path)
+		if err != nil {
+			continue
+		}
+		installs = append(installs, install)
+	}
+
+	return installs, nil
+}
+
+// ProbeJava runs the java executable at path and reports what it is. A JVM
+// that does not exit in time is killed and reported with an error wrapping
+// context.DeadlineExceeded.
+func ProbeJava(ctx context.Context, path string) (JavaInstallation, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, javaProbeTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(probeCtx, path, "-XshowSettings:properties", "-version")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() == nil && probeCtx.Err() != nil {
+			return JavaInstallation{}, fmt.Errorf("%s did not exit within %v: %w", path, javaProbeTimeout, probeCtx.Err())
+		}
+		return JavaInstallation{}, err
+	}
+
+	props := parseJavaProperties(out.Bytes())
+	install := JavaInstallation{
+		Path:    path,
+		Home:    props["java.home"],
+		Version: props["java.version"],
+		Major:   javaMajorFromSpec(props["java.specification.version"]),
+		Vendor:  props["java.vendor"],
+		Arch:    props["os.arch"],
+	}
+	if install.Major == 0 {
+		install.Major = javaMajorFromSpec(install.Version)
+	}
+
+	return install, nil
+}
+
+// probedJava remembers what ProbeJava reported for each executable, so that
+// launches after the first do not run every JVM on the system again. An
+// entry is used while the executable's size and modification time are those
+// it was probed at.
+var (
+	probedJavaMu sync.Mutex
+	probedJava   = map[string]probedInstall{}
+)
+
+type probedInstall struct {
+	size    int64
+	modTime time.Time
+	install JavaInstallation
+}
+
+// probeJavaCached is ProbeJava backed by probedJava. Failed probes are not
+// remembered and run again next time.
+func probeJavaCached(ctx context.Context, path string) (JavaInstallation, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return JavaInstallation{}, err
+	}
+
+	probedJavaMu.Lock()
+	probed, ok := probedJava[path]
+	probedJavaMu.Unlock()
+	if ok && probed.size == info.Size() && probed.modTime.Equal(info.ModTime()) {
+		return probed.install, nil
+	}
+
+	install, err := ProbeJava(ctx, path)
+	if err != nil {
+		return JavaInstallation{}, err
+	}
+
+	probedJavaMu.Lock()
+	probedJava[path] = probedInstall{size: info.Size(), modTime: info.ModTime(), install: install}
+	probedJavaMu.Unlock()
+	return install, nil
+}
+
+// findSystemJava returns the installation SelectJava would pick from
+// DiscoverJava's results, but probes the candidates one at a time and stops
+// at the first match.
+func findSystemJava(ctx context.Context, major uint16) (JavaInstallation, bool, error) {
+	for _, path := range javaCandidates() {
+		if err := ctx.Err(); err != nil {
+			return JavaInstallation{}, false, err
+		}
+
+		install, err := probeJavaCached(ctx, path)
+		if err != nil {
+			continue
+		}
+		if install.Major == major && install.matchesHostArch() {
+			return install, true, nil
+		}
+	}
+
+	return JavaInstallation{}, false, nil
+}
+
+// parseJavaProperties reads the "key = value" lines printed by
+// -XshowSettings:properties. Multi-line values keep only their first line.
+func parseJavaProperties(output []byte) map[string]string {
+	props := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " = ")
+		if !ok {
+			continue
+		}
+		props[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return props
+}
+
+// javaMajorFromSpec turns "1.8", "1.8.0_402", "17" or "21.0.3" into the
+// major version.
+func javaMajorFromSpec(spec string) uint16 {
+	spec = strings.TrimPrefix(spec, "1.")
+	head, _, _ := strings.Cut(spec, ".")
+	head, _, _ = strings.Cut(head, "_")
+	major, err := strconv.ParseUint(head, 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(major)
+}
+
+// matchesHostArch reports whether a runtime reporting os.arch can run on
+// this machine natively.
+func (install JavaInstallation) matchesHostArch() bool {
+	switch runtime.GOARCH {
+	case "amd64":
+		return install.Arch == "amd64" || install.Arch == "x86_64"
+	case "386":
+		return install.Arch == "x86" || install.Arch == "i386" || install.Arch == "i686"
+	case "arm64":
+		return install.Arch == "aarch64" || install.Arch == "arm64"
+	default:
+		return install.Arch == runtime.GOARCH
+	}
+}
+
+// SelectJava picks the first installation of the given major version that
+// runs natively on this machine.
+func SelectJava(installs []JavaInstallation, major uint16) (JavaInstallation, bool) {
+	for _, install := range installs {
+		if install.Major == major && install.matchesHostArch() {
+			return install, true
+		}
+	}
+	return JavaInstallation{}, false
+}
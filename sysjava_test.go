@@ -0,0 +1,171 @@
+package piston
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSystemJava writes a java executable into dir that reports major as its
+// version and appends its path to log every time it runs.
+func fakeSystemJava(t *testing.T, dir string, major string, log string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake java is a shell script")
+	}
+
+	java := filepath.Join(dir, "java")
+	script := `#!/bin/sh
+echo "$0" >> '` + log + `'
+echo "    java.specification.version = ` + major + `" >&2
+echo "    java.version = ` + major + `.0.1" >&2
+echo "    os.arch = ` + runtime.GOARCH + `" >&2
+`
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(java, []byte(script), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return java
+}
+
+func TestFindSystemJavaProbesLazilyAndOnce(t *testing.T) {
+	tmp := t.TempDir()
+	log := filepath.Join(tmp, "java.log")
+	// Majors no real JVM on the machine reports, so only the fakes match.
+	first := fakeSystemJava(t, filepath.Join(tmp, "first"), "98", log)
+	second := fakeSystemJava(t, filepath.Join(tmp, "second"), "99", log)
+
+	t.Setenv("HOME", filepath.Join(tmp, "home"))
+	t.Setenv("JAVA_HOME", "")
+	t.Setenv("PATH", filepath.Dir(first)+string(os.PathListSeparator)+filepath.Dir(second))
+
+	probes := func() []string {
+		t.Helper()
+		data, err := os.ReadFile(log)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return strings.Fields(string(data))
+	}
+
+	ctx := context.Background()
+	for i := range 2 {
+		install, ok, err := findSystemJava(ctx, 98)
+		if err != nil || !ok || install.Path != first {
+			t.Fatalf("run %d: findSystemJava(98) = %+v, %v, %v; want %s", i, install, ok, err, first)
+		}
+	}
+	if got := probes(); len(got) != 1 || got[0] != first {
+		t.Fatalf("probed %v, want only %s once", got, first)
+	}
+
+	install, ok, err := findSystemJava(ctx, 99)
+	if err != nil || !ok || install.Path != second {
+		t.Fatalf("findSystemJava(99) = %+v, %v, %v; want %s", install, ok, err, second)
+	}
+	if got := probes(); len(got) != 2 || got[1] != second {
+		t.Fatalf("probed %v, want %s probed once more", got, second)
+	}
+
+	_, ok, err = findSystemJava(ctx, 97)
+	if err != nil || ok {
+		t.Fatalf("findSystemJava(97) = %v, %v; want no match", ok, err)
+	}
+	if got := probes(); len(got) != 2 {
+		t.Fatalf("probed %v, want the cached results reused", got)
+	}
+}
+
+func TestVerifyJavaRemembersSuccessfulChecks(t *testing.T) {
+	tmp := t.TempDir()
+	log := filepath.Join(tmp, "java.log")
+	java := fakeSystemJava(t, filepath.Join(tmp, "runtime"), "96", log)
+
+	for i := range 2 {
+		err := verifyJavaAt(context.Background(), java, 96)
+		if err != nil {
+			t.Fatalf("check %d: %v", i, err)
+		}
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); len(got) != 1 {
+		t.Fatalf("probed %v, want one run of %s", got, java)
+	}
+}
+
+func TestVerifyJavaTimeoutIsNotBroken(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake java is a shell script")
+	}
+
+	java := filepath.Join(t.TempDir(), "java")
+	err := os.WriteFile(java, []byte("#!/bin/sh\nexec sleep 10\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := javaProbeTimeout
+	javaProbeTimeout = 100 * time.Millisecond
+	defer func() { javaProbeTimeout = timeout }()
+
+	err = verifyJavaAt(context.Background(), java, 21)
+	if err == nil || errors.Is(err, ErrJavaBroken) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a timeout that is not ErrJavaBroken", err)
+	}
+}
+
+func TestJavaForRepairsBrokenPinnedJava(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake java is a shell script")
+	}
+
+	tmp := t.TempDir()
+	good := fakeSystemJava(t, filepath.Join(tmp, "good"), "95", filepath.Join(tmp, "java.log"))
+	broken := filepath.Join(tmp, "broken", "java")
+	err := os.MkdirAll(filepath.Dir(broken), 0755)
+	if err == nil {
+		err = os.WriteFile(broken, []byte("#!/bin/sh\nexit 1\n"), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &VersionMeta{JavaVersion: &JavaVersion{MajorVersion: 95}}
+	provider := &fakeJavaProvider{}
+	launcher := PistonLauncher{
+		BasePath:     tmp,
+		JavaProvider: provider,
+		JavaPaths:    map[uint16]string{95: good},
+		Logger:       log.New(io.Discard, "", 0),
+	}
+	path, err := launcher.javaFor(context.Background(), meta)
+	if err != nil || path != good {
+		t.Fatalf("javaFor = %s, %v; want the pinned %s", path, err, good)
+	}
+
+	launcher.JavaPaths[95] = broken
+	launcher.Java = JavaManual
+	_, err = launcher.javaFor(context.Background(), meta)
+	if !errors.Is(err, ErrJavaBroken) || provider.count("install") != 0 {
+		t.Fatalf("err = %v with %v, want ErrJavaBroken without installing", err, provider.calls)
+	}
+
+	launcher.Java = JavaLazy
+	path, err = launcher.javaFor(context.Background(), meta)
+	if err != nil || path != provider.installed[95] {
+		t.Fatalf("javaFor = %s, %v; want the runtime installed by the provider", path, err)
+	}
+}
@@ -26,12 +26,19 @@ type VersionEntry struct {
 
 type VersionMeta struct {
 	ID             string              `json:"id"`
+	InheritsFrom   string              `json:"inheritsFrom,omitempty"`
 	Arguments      VersionArguments    `json:"arguments"`
 	OlderArguments string              `json:"minecraftArguments,omitempty"`
 	Downloads      map[string]Download `json:"downloads"`
 	Libraries      []Library           `json:"libraries"`
 	AssetIndex     AssetIndex          `json:"assetIndex"`
 	MainClass      string              `json:"mainClass"`
+	JavaVersion    *JavaVersion        `json:"javaVersion,omitempty"`
+}
+
+type JavaVersion struct {
+	Component    string `json:"component"`
+	MajorVersion uint16 `json:"majorVersion"`
 }
 
 type VersionArguments struct {
@@ -87,8 +94,11 @@ func (a *Argument) UnmarshalJSON(data []byte) error {
 type Rule struct {
 	Action string `json:"action"`
 	OS     struct {
-		Name string `json:"name"`
+		Name    string `json:"name"`
+		Arch    string `json:"arch,omitempty"`
+		Version string `json:"version,omitempty"`
 	} `json:"os"`
+	Features map[string]bool `json:"features,omitempty"`
 }
 
 type Download struct {
@@ -118,13 +128,19 @@ type Library struct {
 	Name      string           `json:"name"`
 	Downloads LibraryDownloads `json:"downloads"`
 	Natives   NativeMapping    `json:"natives,omitempty"`
+	Extract   *ExtractRules    `json:"extract,omitempty"`
 	Rules     []Rule           `json:"rules,omitempty"`
 }
 
+type ExtractRules struct {
+	Exclude []string `json:"exclude,omitempty"`
+}
+
 type AssetIndex struct {
 	ID   string `json:"id"`
 	URL  string `json:"url"`
 	SHA1 string `json:"sha1"`
+	Size int    `json:"size"`
 }
 
 type AssetIndexFile struct {
@@ -199,4 +215,90 @@ func (f *FabricMainClass) UnmarshalJSON(data []byte) error {
 	f.Client = asObject.Client
 	f.Server = asObject.Server
 	return nil
-}
\ No newline at end of file
+}
+
+type JavaRuntimeIndex map[string]map[string][]JavaRuntimeEntry
+
+type JavaRuntimeEntry struct {
+	Manifest Download `json:"manifest"`
+	Version  struct {
+		Name     string `json:"name"`
+		Released string `json:"released"`
+	} `json:"version"`
+}
+
+type JavaRuntimeManifest struct {
+	Files map[string]JavaRuntimeFile `json:"files"`
+}
+
+type JavaRuntimeFile struct {
+	Type       string              `json:"type"`
+	Executable bool                `json:"executable,omitempty"`
+	Target     string              `json:"target,omitempty"`
+	Downloads  map[string]Download `json:"downloads,omitempty"`
+}
+
+type AdoptiumRelease struct {
+	ReleaseName string          `json:"release_name"`
+	Binary      AdoptiumBinary  `json:"binary"`
+	Version     AdoptiumVersion `json:"version"`
+}
+
+type AdoptiumBinary struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	ImageType    string `json:"image_type"`
+	Package      struct {
+		Name     string `json:"name"`
+		Link     string `json:"link"`
+		Checksum string `json:"checksum"`
+		Size     int64  `json:"size"`
+	} `json:"package"`
+}
+
+type AdoptiumVersion struct {
+	Major          int    `json:"major"`
+	Minor          int    `json:"minor"`
+	Security       int    `json:"security"`
+	Build          int    `json:"build"`
+	OpenJDKVersion string `json:"openjdk_version"`
+	Semver         string `json:"semver"`
+}
+
+// ForgeInstallProfile is the install_profile.json of a Forge or NeoForge
+// installer (spec 0 and 1, used since Minecraft 1.13).
+type ForgeInstallProfile struct {
+	Spec       int                       `json:"spec"`
+	Profile    string                    `json:"profile"`
+	Version    string                    `json:"version"`
+	Minecraft  string                    `json:"minecraft"`
+	JSON       string                    `json:"json"`
+	Path       string                    `json:"path,omitempty"`
+	Data       map[string]ForgeDataEntry `json:"data"`
+	Processors []ForgeProcessor          `json:"processors"`
+	Libraries  []Library                 `json:"libraries"`
+}
+
+type ForgeDataEntry struct {
+	Client string `json:"client"`
+	Server string `json:"server"`
+}
+
+type ForgeProcessor struct {
+	Sides     []string          `json:"sides,omitempty"`
+	Jar       string            `json:"jar"`
+	Classpath []string          `json:"classpath"`
+	Args      []string          `json:"args"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+}
+
+// MavenMetadata is a maven-metadata.xml listing the versions of an artifact.
+type MavenMetadata struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Versioning struct {
+		Latest   string   `xml:"latest"`
+		Release  string   `xml:"release"`
+		Versions []string `xml:"versions>version"`
+	} `xml:"versioning"`
+}